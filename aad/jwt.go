@@ -25,6 +25,7 @@ package aad
 //	SOFTWARE
 
 import (
+	"context"
 	"crypto/rsa"
 	"crypto/x509"
 	"fmt"
@@ -205,6 +206,12 @@ func (c *TokenProviderConfiguration) getMSIToken(msiEndpoint string) (*adal.Serv
 
 // GetToken gets a CBS JWT
 func (t *TokenProvider) GetToken(audience string) (*auth.Token, error) {
+	return t.GetTokenWithContext(context.Background(), audience)
+}
+
+// GetTokenWithContext gets a CBS JWT, refreshing it from Azure Active Directory if it has expired. The refresh is
+// cancelled if ctx is done.
+func (t *TokenProvider) GetTokenWithContext(ctx context.Context, audience string) (*auth.Token, error) {
 	token := t.tokenProvider.Token()
 	expireTicks, err := strconv.ParseInt(string(token.ExpiresOn), 10, 64)
 	if err != nil {
@@ -213,7 +220,7 @@ func (t *TokenProvider) GetToken(audience string) (*auth.Token, error) {
 	expires := time.Unix(expireTicks, 0)
 
 	if expires.Before(time.Now()) {
-		if err := t.tokenProvider.Refresh(); err != nil {
+		if err := t.tokenProvider.RefreshWithContext(ctx); err != nil {
 			return nil, err
 		}
 		token = t.tokenProvider.Token()
@@ -23,6 +23,10 @@ package auth
 //	OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
 //	SOFTWARE
 
+import (
+	"context"
+)
+
 const (
 	// CBSTokenTypeJWT is the type of token to be used for JWTs. For example Azure Active Directory tokens.
 	CBSTokenTypeJWT TokenType = "jwt"
@@ -46,6 +50,22 @@ type (
 	TokenProvider interface {
 		GetToken(uri string) (*Token, error)
 	}
+
+	// ContextTokenProvider abstracts the fetching of authentication tokens where the fetch can be cancelled or bounded
+	// by a context
+	ContextTokenProvider interface {
+		GetTokenWithContext(ctx context.Context, audience string) (*Token, error)
+	}
+
+	// contextTokenProvider adapts a TokenProvider to a ContextTokenProvider
+	contextTokenProvider struct {
+		provider TokenProvider
+	}
+
+	// tokenProvider adapts a ContextTokenProvider to a TokenProvider
+	tokenProvider struct {
+		provider ContextTokenProvider
+	}
 )
 
 // NewToken constructs a new auth token
@@ -56,3 +76,66 @@ func NewToken(tokenType TokenType, token, expiry string) *Token {
 		Expiry:    expiry,
 	}
 }
+
+// AsContextTokenProvider returns provider as a ContextTokenProvider. If provider does not implement
+// ContextTokenProvider, the returned adapter checks the context before and after calling GetToken but is not able to
+// interrupt the call itself.
+func AsContextTokenProvider(provider TokenProvider) ContextTokenProvider {
+	if adapter, ok := provider.(*tokenProvider); ok {
+		return adapter.provider
+	}
+	if ctxProvider, ok := provider.(ContextTokenProvider); ok {
+		return ctxProvider
+	}
+	return &contextTokenProvider{provider: provider}
+}
+
+// AsTokenProvider returns provider as a TokenProvider. If provider does not implement TokenProvider, the returned
+// adapter calls GetTokenWithContext with a background context.
+func AsTokenProvider(provider ContextTokenProvider) TokenProvider {
+	if adapter, ok := provider.(*contextTokenProvider); ok {
+		return adapter.provider
+	}
+	if legacyProvider, ok := provider.(TokenProvider); ok {
+		return legacyProvider
+	}
+	return &tokenProvider{provider: provider}
+}
+
+// GetToken fetches a token for the audience from provider, using GetTokenWithContext if provider implements
+// ContextTokenProvider
+func GetToken(ctx context.Context, provider TokenProvider, audience string) (*Token, error) {
+	return AsContextTokenProvider(provider).GetTokenWithContext(ctx, audience)
+}
+
+// GetTokenWithContext implements ContextTokenProvider
+func (p *contextTokenProvider) GetTokenWithContext(ctx context.Context, audience string) (*Token, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	token, err := p.provider.GetToken(audience)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// GetToken implements TokenProvider
+func (p *contextTokenProvider) GetToken(audience string) (*Token, error) {
+	return p.provider.GetToken(audience)
+}
+
+// GetToken implements TokenProvider
+func (p *tokenProvider) GetToken(audience string) (*Token, error) {
+	return p.provider.GetTokenWithContext(context.Background(), audience)
+}
+
+// GetTokenWithContext implements ContextTokenProvider
+func (p *tokenProvider) GetTokenWithContext(ctx context.Context, audience string) (*Token, error) {
+	return p.provider.GetTokenWithContext(ctx, audience)
+}
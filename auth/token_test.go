@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type legacyProvider struct {
+	calls int
+}
+
+func (p *legacyProvider) GetToken(uri string) (*Token, error) {
+	p.calls++
+	return NewToken(CBSTokenTypeSAS, "legacy", "0"), nil
+}
+
+type ctxProvider struct {
+	ctx context.Context
+}
+
+func (p *ctxProvider) GetTokenWithContext(ctx context.Context, audience string) (*Token, error) {
+	p.ctx = ctx
+	return NewToken(CBSTokenTypeJWT, "ctx", "0"), nil
+}
+
+func TestGetTokenPrefersContext(t *testing.T) {
+	type ctxKey struct{}
+	ctx := context.WithValue(context.Background(), ctxKey{}, "value")
+
+	provider := &ctxProvider{}
+	token, err := GetToken(ctx, AsTokenProvider(provider), "aud")
+	require.NoError(t, err)
+	require.Equal(t, "ctx", token.Token)
+	require.Equal(t, "value", provider.ctx.Value(ctxKey{}), "context is passed through the adapter")
+}
+
+func TestGetTokenLegacyProvider(t *testing.T) {
+	provider := &legacyProvider{}
+	token, err := GetToken(context.Background(), provider, "aud")
+	require.NoError(t, err)
+	require.Equal(t, "legacy", token.Token)
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = GetToken(cancelled, provider, "aud")
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, 1, provider.calls, "provider is not called with a cancelled context")
+}
+
+func TestAdaptersRoundTrip(t *testing.T) {
+	legacy := &legacyProvider{}
+	require.Same(t, legacy, AsTokenProvider(AsContextTokenProvider(legacy)))
+
+	ctxP := &ctxProvider{}
+	require.Same(t, ctxP, AsContextTokenProvider(AsTokenProvider(ctxP)))
+}
@@ -26,6 +26,7 @@ package cbs
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
@@ -44,32 +45,139 @@ const (
 	cbsTokenTypeKey      = "type"
 	cbsAudienceKey       = "name"
 	cbsExpirationKey     = "expiration"
+	serverTimeoutKey     = "server-timeout"
 )
 
+type (
+	// putTokenCall is the state shared by the attempts of a put-token request
+	putTokenCall struct {
+		attemptTimeout time.Duration
+		event          *ClaimEvent
+	}
+
+	putTokenCallKey struct{}
+
+	// attemptTimeoutError is returned for a put-token attempt which timed out while there was still time to try again
+	attemptTimeoutError struct {
+		err error
+	}
+)
+
+// newLink opens the $cbs link claims are negotiated over
+var newLink = func(ctx context.Context, conn *amqp.Conn, opts ...rpc.LinkOption) (*rpc.Link, error) {
+	return rpc.NewLink(ctx, conn, cbsAddress, opts...)
+}
+
 // NegotiateClaim attempts to put a token to the $cbs management endpoint to negotiate auth for the given audience
 func NegotiateClaim(ctx context.Context, audience string, conn *amqp.Conn, provider auth.TokenProvider) error {
 	ctx, span := tracing.StartSpanFromContext(ctx, "az-amqp-common.cbs.NegotiateClaim")
 	defer span.End()
 
-	link, err := rpc.NewLink(ctx, conn, cbsAddress)
+	_, err := negotiateClaim(ctx, audience, conn, provider)
+	return err
+}
+
+// NegotiateClaimWithOptions attempts to put a token to the $cbs management endpoint to negotiate auth for the given
+// audience, using the retry and timeout policy described by opts
+func NegotiateClaimWithOptions(ctx context.Context, audience string, conn *amqp.Conn, provider auth.TokenProvider, opts ...ClaimOption) error {
+	ctx, span := tracing.StartSpanFromContext(ctx, "az-amqp-common.cbs.NegotiateClaimWithOptions")
+	defer span.End()
+
+	_, err := negotiateClaim(ctx, audience, conn, provider, opts...)
+	return err
+}
+
+// negotiateClaim puts a token for the audience and returns the token which was accepted by the service, so callers
+// are able to schedule a renewal before it expires
+func negotiateClaim(ctx context.Context, audience string, conn *amqp.Conn, provider auth.TokenProvider, opts ...ClaimOption) (*auth.Token, error) {
+	cfg, err := newClaimConfig(opts...)
 	if err != nil {
-		tab.For(ctx).Error(err)
-		return err
+		return nil, err
 	}
-	defer func() {
-		if err := link.Close(ctx); err != nil {
+
+	return observeClaim(ctx, conn, audience, cfg, func(event *ClaimEvent) (*auth.Token, error) {
+		link, err := newLink(ctx, conn, rpc.LinkWithInterceptors(attemptPutToken))
+		if err != nil {
 			tab.For(ctx).Error(err)
+			return nil, err
 		}
-	}()
+		defer func() {
+			if err := link.Close(ctx); err != nil {
+				tab.For(ctx).Error(err)
+			}
+		}()
+
+		return putToken(ctx, link, provider, cfg, event)
+	})
+}
+
+// observeClaim runs negotiate, notifying the observers and registry configured in cfg as the negotiation starts and
+// finishes
+func observeClaim(ctx context.Context, conn *amqp.Conn, audience string, cfg *claimConfig, negotiate func(event *ClaimEvent) (*auth.Token, error)) (*auth.Token, error) {
+	o := cfg.observers
+	if cfg.registry != nil {
+		o = append(o[:len(o):len(o)], cfg.registry.observer(conn))
+	}
+
+	event := ClaimEvent{
+		Audience: audience,
+		Started:  time.Now(),
+	}
+	o.start(ctx, event)
+
+	token, err := negotiate(&event)
+	event.Err = err
+	o.finish(ctx, event)
+	return token, err
+}
 
-	token, err := provider.GetToken(audience)
+// putToken fetches a token for the audience of event from the provider and puts it to the $cbs endpoint via link,
+// recording what it learns about the negotiation in event
+func putToken(ctx context.Context, link *rpc.Link, provider auth.TokenProvider, cfg *claimConfig, event *ClaimEvent) (*auth.Token, error) {
+	audience := event.Audience
+	token, err := auth.GetToken(ctx, provider, audience)
 	if err != nil {
 		tab.For(ctx).Error(err)
-		return err
+		return nil, err
+	}
+
+	event.TokenType = token.TokenType
+	if expiry, err := parseExpiry(token.Expiry); err == nil {
+		event.Expiry = expiry
 	}
 
 	tab.For(ctx).Debug(fmt.Sprintf("negotiating claim for audience %s with token type %s and expiry of %s", audience, token.TokenType, token.Expiry))
-	msg := &amqp.Message{
+	msg := newPutTokenRequest(token, audience)
+
+	if cfg.serverTimeout > 0 {
+		msg.ApplicationProperties[serverTimeoutKey] = uint(cfg.serverTimeout / time.Millisecond)
+	}
+
+	ctx = context.WithValue(ctx, putTokenCallKey{}, &putTokenCall{attemptTimeout: cfg.attemptTimeout, event: event})
+	if _, err := link.RetryableRPCWithPolicy(ctx, cfg.retryPolicy, msg); err != nil {
+		var statusErr *rpc.StatusError
+		if errors.As(err, &statusErr) {
+			err = &ClaimError{
+				Code:        statusErr.Code,
+				Description: statusErr.Description,
+				Condition:   statusErr.Condition,
+				Response:    statusErr.Message,
+				Audience:    audience,
+				TokenType:   token.TokenType,
+				StatusError: statusErr,
+			}
+		}
+		tab.For(ctx).Error(err)
+		return nil, err
+	}
+
+	tab.For(ctx).Debug(fmt.Sprintf("negotiated claim for audience %s with response code %d", audience, event.StatusCode))
+	return token, nil
+}
+
+// newPutTokenRequest builds the request which puts token to the $cbs endpoint for audience
+func newPutTokenRequest(token *auth.Token, audience string) *amqp.Message {
+	return &amqp.Message{
 		Value: token.Token,
 		ApplicationProperties: map[string]interface{}{
 			cbsOperationKey:  cbsOperationPutToken,
@@ -78,13 +186,48 @@ func NegotiateClaim(ctx context.Context, audience string, conn *amqp.Conn, provi
 			cbsExpirationKey: token.Expiry,
 		},
 	}
+}
+
+// attemptPutToken intercepts the requests sent over $cbs links. Each attempt of a put-token request is bounded by the
+// attempt timeout of its claim, and counted on the claim event.
+func attemptPutToken(ctx context.Context, msg *amqp.Message, invoker rpc.Invoker) (*rpc.Response, error) {
+	call, ok := ctx.Value(putTokenCallKey{}).(*putTokenCall)
+	if !ok {
+		return invoker(ctx, msg)
+	}
+
+	call.event.Attempts++
+	attemptCtx := ctx
+	if call.attemptTimeout > 0 {
+		var cancel context.CancelFunc
+		attemptCtx, cancel = context.WithTimeout(ctx, call.attemptTimeout)
+		defer cancel()
+	}
 
-	res, err := link.RetryableRPC(ctx, 3, 1*time.Second, msg)
+	res, err := invoker(attemptCtx, msg)
 	if err != nil {
-		tab.For(ctx).Error(err)
-		return err
+		if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+			// only this attempt timed out, so there is still time to try again
+			return nil, &attemptTimeoutError{err: err}
+		}
+		return nil, err
 	}
 
-	tab.For(ctx).Debug(fmt.Sprintf("negotiated with response code %d and message: %s", res.Code, res.Description))
-	return nil
+	call.event.StatusCode = res.Code
+	return res, nil
+}
+
+// Error implementation for attemptTimeoutError
+func (e *attemptTimeoutError) Error() string {
+	return "put-token attempt timed out: " + e.err.Error()
+}
+
+// Unwrap returns the error of the attempt
+func (e *attemptTimeoutError) Unwrap() error {
+	return e.err
+}
+
+// Retryable returns true, since the next attempt may succeed in time
+func (e *attemptTimeoutError) Retryable() bool {
+	return true
 }
@@ -0,0 +1,218 @@
+package cbs
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/Azure/azure-amqp-common-go/v4/auth"
+	"github.com/Azure/azure-amqp-common-go/v4/rpc"
+	"github.com/Azure/go-amqp"
+)
+
+// staticProvider returns the same token for every audience
+type staticProvider struct {
+	token *auth.Token
+}
+
+func (p staticProvider) GetToken(string) (*auth.Token, error) {
+	return p.token, nil
+}
+
+func testToken() *auth.Token {
+	return auth.NewToken(auth.CBSTokenTypeJWT, "token", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10))
+}
+
+func putTokenResponse(code int, description string) *amqp.Message {
+	return &amqp.Message{
+		Properties: &amqp.MessageProperties{},
+		ApplicationProperties: map[string]interface{}{
+			statusCodeKey:        int32(code),
+			statusDescriptionKey: description,
+		},
+	}
+}
+
+// putTokenExchanges are the exchanges of a fake $cbs endpoint which answers the put-token requests for token and
+// audience with responses, in order. A nil response is never sent.
+func putTokenExchanges(t *testing.T, token *auth.Token, audience string, responses ...*amqp.Message) []rpc.Exchange {
+	request, err := rpc.NewRecordedMessage(newPutTokenRequest(token, audience))
+	require.NoError(t, err)
+
+	exchanges := make([]rpc.Exchange, len(responses))
+	for i, res := range responses {
+		exchanges[i].Request = request
+		if res != nil {
+			exchanges[i].Response, err = rpc.NewRecordedMessage(res)
+			require.NoError(t, err)
+		}
+	}
+	return exchanges
+}
+
+// fakeCBS is a $cbs endpoint which answers put-token requests from a recording
+type fakeCBS struct {
+	mu     sync.Mutex
+	links  []*rpc.Link
+	attach func() error
+	// detach makes the endpoint detach every link as soon as it is opened
+	detach bool
+}
+
+// withFakeCBS makes claims negotiated for the rest of the test put tokens to a fake $cbs endpoint with the exchanges
+func withFakeCBS(t *testing.T, exchanges ...[]rpc.Exchange) *fakeCBS {
+	recording := &rpc.Recording{}
+	for _, e := range exchanges {
+		recording.Exchanges = append(recording.Exchanges, e...)
+	}
+
+	cbs := &fakeCBS{}
+	open := newLink
+	newLink = func(ctx context.Context, conn *amqp.Conn, opts ...rpc.LinkOption) (*rpc.Link, error) {
+		cbs.mu.Lock()
+		attach := cbs.attach
+		cbs.mu.Unlock()
+
+		if attach != nil {
+			if err := attach(); err != nil {
+				return nil, err
+			}
+		}
+
+		link, err := rpc.NewReplayLink(recording, opts...)
+		if err != nil {
+			return nil, err
+		}
+
+		cbs.mu.Lock()
+		cbs.links = append(cbs.links, link)
+		detach := cbs.detach
+		cbs.mu.Unlock()
+
+		if detach {
+			if err := link.Close(ctx); err != nil {
+				return nil, err
+			}
+		}
+		return link, nil
+	}
+	t.Cleanup(func() { newLink = open })
+	return cbs
+}
+
+// opened returns the links which have been opened to the endpoint
+func (c *fakeCBS) opened() []*rpc.Link {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]*rpc.Link(nil), c.links...)
+}
+
+func TestNegotiateClaimRetriesRetryableStatus(t *testing.T) {
+	token := testToken()
+	withFakeCBS(t, putTokenExchanges(t, token, testAudience,
+		putTokenResponse(http.StatusServiceUnavailable, "try again"),
+		putTokenResponse(http.StatusAccepted, "Accepted")))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := NegotiateClaimWithOptions(ctx, testAudience, nil, staticProvider{token},
+		ClaimWithRetryPolicy(rpc.FixedRetryPolicy{MaxAttempts: 3, Delay: time.Millisecond}))
+	require.NoError(t, err)
+}
+
+func TestNegotiateClaimRetriesAfterAttemptTimeout(t *testing.T) {
+	token := testToken()
+	withFakeCBS(t, putTokenExchanges(t, token, testAudience,
+		nil,
+		putTokenResponse(http.StatusAccepted, "Accepted")))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	err := NegotiateClaimWithOptions(ctx, testAudience, nil, staticProvider{token},
+		ClaimWithRetryPolicy(rpc.FixedRetryPolicy{MaxAttempts: 2, Delay: time.Millisecond}),
+		ClaimWithAttemptTimeout(50*time.Millisecond))
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+}
+
+func TestNegotiateClaimGivesUpAfterAttemptTimeouts(t *testing.T) {
+	token := testToken()
+	withFakeCBS(t, putTokenExchanges(t, token, testAudience, nil, nil))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := NegotiateClaimWithOptions(ctx, testAudience, nil, staticProvider{token},
+		ClaimWithRetryPolicy(rpc.FixedRetryPolicy{MaxAttempts: 2, Delay: time.Millisecond}),
+		ClaimWithAttemptTimeout(20*time.Millisecond))
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestNegotiateClaimReportsRejections(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// a SAS token signed with a key the service does not know
+	h, err := NewHandler(HandlerWithKey(testKeyName, testKey))
+	require.NoError(t, err)
+	token := auth.NewToken(auth.CBSTokenTypeSAS, "SharedAccessSignature sr=x&sig=y&se=1&skn=unknown", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10))
+	res := h.HandleRequest(ctx, newPutTokenRequest(token, testAudience))
+	withFakeCBS(t, putTokenExchanges(t, token, testAudience, res))
+
+	err = NegotiateClaim(ctx, testAudience, nil, staticProvider{token})
+	require.True(t, IsUnauthorized(err), "401 responses are not retried: %v", err)
+
+	var claimErr *ClaimError
+	require.ErrorAs(t, err, &claimErr)
+	require.Equal(t, testAudience, claimErr.Audience)
+	require.Equal(t, auth.CBSTokenTypeSAS, claimErr.TokenType)
+	require.NotNil(t, claimErr.Response)
+	require.Empty(t, claimErr.Condition)
+
+	// an audience which does not exist
+	h, err = NewHandler(HandlerWithTokenValidator(auth.CBSTokenTypeJWT, func(ctx context.Context, token, audience string) error {
+		return &ClaimError{Code: http.StatusNotFound, Description: "no such entity"}
+	}))
+	require.NoError(t, err)
+	token = testToken()
+	res = h.HandleRequest(ctx, newPutTokenRequest(token, testAudience))
+	res.ApplicationProperties["error-condition"] = "amqp:not-found"
+	withFakeCBS(t, putTokenExchanges(t, token, testAudience, res))
+
+	err = NegotiateClaim(ctx, testAudience, nil, staticProvider{token})
+	require.True(t, IsNotFound(err), "404 responses are not retried: %v", err)
+	require.ErrorAs(t, err, &claimErr)
+	require.Equal(t, "no such entity", claimErr.Description)
+	require.Equal(t, "amqp:not-found", claimErr.Condition)
+	require.Contains(t, claimErr.Error(), "amqp:not-found")
+	require.EqualValues(t, http.StatusNotFound, claimErr.Response.ApplicationProperties[statusCodeKey])
+}
+
+func TestNegotiateClaimKeepsThrottling(t *testing.T) {
+	token := testToken()
+	busy := putTokenResponse(http.StatusBadRequest, "busy")
+	busy.ApplicationProperties["error-condition"] = "com.microsoft:server-busy"
+	busy.ApplicationProperties["com.microsoft:retry-after"] = int64(1500)
+	withFakeCBS(t, putTokenExchanges(t, token, testAudience, busy))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := NegotiateClaimWithOptions(ctx, testAudience, nil, staticProvider{token},
+		ClaimWithRetryPolicy(rpc.FixedRetryPolicy{MaxAttempts: 1}))
+	require.True(t, IsRetryable(err), "a server-busy rejection is throttling: %v", err)
+
+	var statusErr *rpc.StatusError
+	require.ErrorAs(t, err, &statusErr)
+	require.True(t, statusErr.Throttled)
+	require.Equal(t, 1500*time.Millisecond, statusErr.RetryAfter)
+}
@@ -0,0 +1,106 @@
+package cbs
+
+//	MIT License
+//
+//	Copyright (c) Microsoft Corporation. All rights reserved.
+//
+//	Permission is hereby granted, free of charge, to any person obtaining a copy
+//	of this software and associated documentation files (the "Software"), to deal
+//	in the Software without restriction, including without limitation the rights
+//	to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//	copies of the Software, and to permit persons to whom the Software is
+//	furnished to do so, subject to the following conditions:
+//
+//	The above copyright notice and this permission notice shall be included in all
+//	copies or substantial portions of the Software.
+//
+//	THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//	AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//	LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//	OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//	SOFTWARE
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Azure/azure-amqp-common-go/v4/auth"
+	"github.com/Azure/azure-amqp-common-go/v4/rpc"
+	"github.com/Azure/go-amqp"
+)
+
+type (
+	// ClaimError is returned when the $cbs endpoint rejects a put-token request
+	ClaimError struct {
+		// Code is the status code of the put-token response
+		Code int
+		// Description is the status description of the put-token response
+		Description string
+		// Condition is the error-condition of the put-token response, if it has one
+		Condition string
+		// Response is the raw put-token response. It is nil for errors which did not come from a response.
+		Response *amqp.Message
+		// Audience is the audience the claim was negotiated for
+		Audience string
+		// TokenType is the type of the token which was rejected
+		TokenType auth.TokenType
+		// StatusError is the error the put-token request failed with, carrying its throttling hints. It is nil for
+		// errors which did not come from a response.
+		StatusError *rpc.StatusError
+	}
+)
+
+// Error implementation for ClaimError
+func (e *ClaimError) Error() string {
+	if e.Condition != "" {
+		return fmt.Sprintf("failed to negotiate claim for audience %s with token type %s: status code %d (%s) and description: %s", e.Audience, e.TokenType, e.Code, e.Condition, e.Description)
+	}
+	return fmt.Sprintf("failed to negotiate claim for audience %s with token type %s: status code %d and description: %s", e.Audience, e.TokenType, e.Code, e.Description)
+}
+
+// Unwrap returns the StatusError of the put-token response, if there is one
+func (e *ClaimError) Unwrap() error {
+	if e.StatusError == nil {
+		return nil
+	}
+	return e.StatusError
+}
+
+// Retryable returns true if the put-token request may succeed if it is sent again, as is the case for server errors,
+// timeouts and throttling. Errors which came from a response are classified like their StatusError, so a
+// com.microsoft:server-busy condition counts as throttling.
+func (e *ClaimError) Retryable() bool {
+	if e.StatusError != nil {
+		return e.StatusError.Retryable()
+	}
+	return e.Code >= 500 || e.Code == http.StatusRequestTimeout || e.Code == http.StatusTooManyRequests
+}
+
+// IsUnauthorized returns true if err is a ClaimError caused by an invalid or expired token
+func IsUnauthorized(err error) bool {
+	return hasClaimCode(err, http.StatusUnauthorized)
+}
+
+// IsForbidden returns true if err is a ClaimError caused by a token which does not grant access to the audience
+func IsForbidden(err error) bool {
+	return hasClaimCode(err, http.StatusForbidden)
+}
+
+// IsNotFound returns true if err is a ClaimError caused by an audience which does not exist
+func IsNotFound(err error) bool {
+	return hasClaimCode(err, http.StatusNotFound)
+}
+
+// IsRetryable returns true if err is a ClaimError which may succeed if the claim is negotiated again
+func IsRetryable(err error) bool {
+	var claimErr *ClaimError
+	return errors.As(err, &claimErr) && claimErr.Retryable()
+}
+
+func hasClaimCode(err error, code int) bool {
+	var claimErr *ClaimError
+	return errors.As(err, &claimErr) && claimErr.Code == code
+}
@@ -0,0 +1,47 @@
+package cbs
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/Azure/azure-amqp-common-go/v4/auth"
+	"github.com/Azure/azure-amqp-common-go/v4/rpc"
+)
+
+func TestClaimErrorClassification(t *testing.T) {
+	newErr := func(code int) error {
+		return fmt.Errorf("wrapped: %w", &ClaimError{
+			Code:      code,
+			Audience:  "amqps://ns.servicebus.windows.net/queue",
+			TokenType: auth.CBSTokenTypeSAS,
+		})
+	}
+
+	require.True(t, IsUnauthorized(newErr(401)))
+	require.False(t, IsUnauthorized(newErr(404)))
+	require.True(t, IsForbidden(newErr(403)))
+	require.True(t, IsNotFound(newErr(404)))
+
+	for _, code := range []int{408, 429, 500, 503} {
+		require.True(t, IsRetryable(newErr(code)), "code %d should be retryable", code)
+	}
+	for _, code := range []int{400, 401, 403, 404, 410} {
+		require.False(t, IsRetryable(newErr(code)), "code %d should not be retryable", code)
+	}
+
+	require.False(t, IsUnauthorized(errors.New("status code 401")))
+
+	throttled := &ClaimError{Code: 400, StatusError: &rpc.StatusError{Code: 400, Throttled: true}}
+	require.True(t, IsRetryable(throttled), "classified like its StatusError")
+	var statusErr *rpc.StatusError
+	require.ErrorAs(t, throttled, &statusErr)
+	require.Nil(t, (&ClaimError{Code: 400}).Unwrap())
+
+	var claimErr *ClaimError
+	require.ErrorAs(t, newErr(401), &claimErr)
+	require.Equal(t, "amqps://ns.servicebus.windows.net/queue", claimErr.Audience)
+	require.Contains(t, claimErr.Error(), "status code 401")
+}
@@ -0,0 +1,335 @@
+package cbs
+
+//	MIT License
+//
+//	Copyright (c) Microsoft Corporation. All rights reserved.
+//
+//	Permission is hereby granted, free of charge, to any person obtaining a copy
+//	of this software and associated documentation files (the "Software"), to deal
+//	in the Software without restriction, including without limitation the rights
+//	to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//	copies of the Software, and to permit persons to whom the Software is
+//	furnished to do so, subject to the following conditions:
+//
+//	The above copyright notice and this permission notice shall be included in all
+//	copies or substantial portions of the Software.
+//
+//	THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//	AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//	LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//	OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//	SOFTWARE
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/devigned/tab"
+
+	"github.com/Azure/azure-amqp-common-go/v4/auth"
+	"github.com/Azure/azure-amqp-common-go/v4/internal/tracing"
+	"github.com/Azure/azure-amqp-common-go/v4/rpc"
+	"github.com/Azure/go-amqp"
+)
+
+type (
+	// Negotiator negotiates claims over a single, long-lived $cbs link on an AMQP connection. The link is opened on
+	// first use and re-opened if it is detached. Put-token requests from concurrent callers are pipelined over the same
+	// link. A Negotiator must be closed when it is no longer needed.
+	Negotiator struct {
+		conn           *amqp.Conn
+		maxConcurrency int
+		claimOpts      []ClaimOption
+
+		mu      sync.Mutex
+		link    *rpc.Link
+		opening chan struct{} // closed once the link being opened is attached or has failed
+		closed  bool
+	}
+
+	// NegotiatorOption provides a way to customize the construction of a Negotiator
+	NegotiatorOption func(n *Negotiator) error
+
+	// ClaimResult is the outcome of negotiating the claim for a single audience
+	ClaimResult struct {
+		// Audience is the audience the claim was negotiated for
+		Audience string
+		// Expiry is the expiry of the token which was accepted. It is zero if negotiation failed.
+		Expiry time.Time
+		// Err is the error which caused negotiation to fail, or nil if it succeeded
+		Err error
+	}
+)
+
+const defaultMaxConcurrency = 16
+
+// ErrNegotiatorClosed is returned when a claim is negotiated through a Negotiator which has been closed
+var ErrNegotiatorClosed = errors.New("cbs negotiator is closed")
+
+// NegotiatorWithMaxConcurrency configures how many put-token requests NegotiateClaims keeps in flight at once
+func NegotiatorWithMaxConcurrency(max int) NegotiatorOption {
+	return func(n *Negotiator) error {
+		if max < 1 {
+			return errors.New("max concurrency must be at least 1")
+		}
+		n.maxConcurrency = max
+		return nil
+	}
+}
+
+// NegotiatorWithClaimOptions configures the default retry and timeout policy for claims negotiated by the Negotiator.
+// Options passed to NegotiateClaim are applied after these.
+func NegotiatorWithClaimOptions(opts ...ClaimOption) NegotiatorOption {
+	return func(n *Negotiator) error {
+		if _, err := newClaimConfig(opts...); err != nil {
+			return err
+		}
+		n.claimOpts = append(n.claimOpts, opts...)
+		return nil
+	}
+}
+
+// NewNegotiator builds a Negotiator for the given connection. No link is opened until the first claim is negotiated.
+func NewNegotiator(conn *amqp.Conn, opts ...NegotiatorOption) (*Negotiator, error) {
+	n := &Negotiator{
+		conn:           conn,
+		maxConcurrency: defaultMaxConcurrency,
+	}
+
+	for _, opt := range opts {
+		if err := opt(n); err != nil {
+			return nil, err
+		}
+	}
+	return n, nil
+}
+
+// NegotiateClaims negotiates the claims for all of the audiences over a single $cbs link on conn, keeping a bounded
+// number of put-token requests in flight. Results are returned in the order of audiences. If any claim could not be
+// negotiated, the returned error describes the first failure.
+func NegotiateClaims(ctx context.Context, conn *amqp.Conn, provider auth.TokenProvider, audiences ...string) ([]ClaimResult, error) {
+	ctx, span := tracing.StartSpanFromContext(ctx, "az-amqp-common.cbs.NegotiateClaims")
+	defer span.End()
+
+	n, err := NewNegotiator(conn)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := n.Close(ctx); err != nil {
+			tab.For(ctx).Error(err)
+		}
+	}()
+
+	return n.NegotiateClaims(ctx, provider, audiences...)
+}
+
+// RefresherWithNegotiator configures a Refresher to renew its claim through n rather than opening a new $cbs link
+// for each renewal
+func RefresherWithNegotiator(n *Negotiator) RefresherOption {
+	return func(r *Refresher) error {
+		r.negotiate = func(ctx context.Context) (*auth.Token, error) {
+			return n.negotiateClaim(ctx, r.audience, r.provider, r.claimOpts...)
+		}
+		return nil
+	}
+}
+
+// NegotiateClaim puts a token to the $cbs endpoint to negotiate auth for the given audience
+func (n *Negotiator) NegotiateClaim(ctx context.Context, audience string, provider auth.TokenProvider, opts ...ClaimOption) error {
+	ctx, span := tracing.StartSpanFromContext(ctx, "az-amqp-common.cbs.Negotiator.NegotiateClaim")
+	defer span.End()
+
+	_, err := n.negotiateClaim(ctx, audience, provider, opts...)
+	return err
+}
+
+// NegotiateClaims negotiates the claims for all of the audiences, keeping a bounded number of put-token requests in
+// flight. Results are returned in the order of audiences. If any claim could not be negotiated, the returned error
+// describes the first failure.
+func (n *Negotiator) NegotiateClaims(ctx context.Context, provider auth.TokenProvider, audiences ...string) ([]ClaimResult, error) {
+	ctx, span := tracing.StartSpanFromContext(ctx, "az-amqp-common.cbs.Negotiator.NegotiateClaims")
+	defer span.End()
+
+	results := make([]ClaimResult, len(audiences))
+	sem := make(chan struct{}, n.maxConcurrency)
+	var wg sync.WaitGroup
+
+	for i, audience := range audiences {
+		results[i].Audience = audience
+
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			results[i].Err = ctx.Err()
+			continue
+		}
+
+		wg.Add(1)
+		go func(result *ClaimResult) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			token, err := n.negotiateClaim(ctx, result.Audience, provider)
+			if err == nil {
+				result.Expiry, err = parseExpiry(token.Expiry)
+			}
+			result.Err = err
+		}(&results[i])
+	}
+	wg.Wait()
+
+	failed := 0
+	var firstErr error
+	for _, result := range results {
+		if result.Err != nil {
+			if firstErr == nil {
+				firstErr = result.Err
+			}
+			failed++
+		}
+	}
+
+	if firstErr != nil {
+		err := fmt.Errorf("failed to negotiate claims for %d of %d audiences: %w", failed, len(audiences), firstErr)
+		tab.For(ctx).Error(err)
+		return results, err
+	}
+	return results, nil
+}
+
+// Close closes the $cbs link, if one is open. Claims can not be negotiated after the Negotiator is closed.
+func (n *Negotiator) Close(ctx context.Context) error {
+	ctx, span := tracing.StartSpanFromContext(ctx, "az-amqp-common.cbs.Negotiator.Close")
+	defer span.End()
+
+	n.mu.Lock()
+	link := n.link
+	n.link = nil
+	n.closed = true
+	n.mu.Unlock()
+
+	if link == nil {
+		return nil
+	}
+
+	if err := link.Close(ctx); err != nil {
+		tab.For(ctx).Error(err)
+		return err
+	}
+	return nil
+}
+
+func (n *Negotiator) negotiateClaim(ctx context.Context, audience string, provider auth.TokenProvider, opts ...ClaimOption) (*auth.Token, error) {
+	cfg, err := newClaimConfig(append(n.claimOpts[:len(n.claimOpts):len(n.claimOpts)], opts...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	return observeClaim(ctx, n.conn, audience, cfg, func(event *ClaimEvent) (*auth.Token, error) {
+		link, err := n.getLink(ctx)
+		if err != nil {
+			tab.For(ctx).Error(err)
+			return nil, err
+		}
+
+		token, err := putToken(ctx, link, provider, cfg, event)
+		if err == nil || !rpc.IsDetached(err) {
+			return token, err
+		}
+
+		// the service detaches idle links, so the token is put once more on a new link. Put-token is idempotent, so
+		// this is safe even if the request made it to the service before the detach.
+		n.discardLink(ctx, link)
+		if link, err = n.getLink(ctx); err != nil {
+			tab.For(ctx).Error(err)
+			return nil, err
+		}
+
+		token, err = putToken(ctx, link, provider, cfg, event)
+		if err != nil && rpc.IsDetached(err) {
+			n.discardLink(ctx, link)
+		}
+		return token, err
+	})
+}
+
+// getLink returns the open $cbs link, opening a new one if there is none. The link is opened without holding n.mu;
+// concurrent callers wait for it to be opened rather than opening their own.
+func (n *Negotiator) getLink(ctx context.Context) (*rpc.Link, error) {
+	for {
+		n.mu.Lock()
+		if n.closed {
+			n.mu.Unlock()
+			return nil, ErrNegotiatorClosed
+		}
+
+		if n.link != nil {
+			link := n.link
+			n.mu.Unlock()
+			return link, nil
+		}
+
+		if opening := n.opening; opening != nil {
+			n.mu.Unlock()
+
+			select {
+			case <-opening:
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		opening := make(chan struct{})
+		n.opening = opening
+		n.mu.Unlock()
+
+		link, err := newLink(ctx, n.conn, rpc.LinkWithInterceptors(attemptPutToken))
+
+		n.mu.Lock()
+		n.opening = nil
+		close(opening)
+		closed := n.closed
+		if err == nil && !closed {
+			n.link = link
+		}
+		n.mu.Unlock()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if closed {
+			// the Negotiator was closed while the link was being opened
+			if err := link.Close(ctx); err != nil {
+				tab.For(ctx).Debug("error closing $cbs link: " + err.Error())
+			}
+			return nil, ErrNegotiatorClosed
+		}
+		return link, nil
+	}
+}
+
+// discardLink drops link so the next negotiation opens a new one. Concurrent callers may observe the same detach, so
+// the link is only dropped if it has not been replaced already.
+func (n *Negotiator) discardLink(ctx context.Context, link *rpc.Link) {
+	n.mu.Lock()
+	if n.link != link {
+		n.mu.Unlock()
+		return
+	}
+	n.link = nil
+	n.mu.Unlock()
+
+	if err := link.Close(ctx); err != nil {
+		tab.For(ctx).Debug("error closing detached $cbs link: " + err.Error())
+	}
+}
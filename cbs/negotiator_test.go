@@ -0,0 +1,256 @@
+package cbs
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/Azure/azure-amqp-common-go/v4/auth"
+	"github.com/Azure/azure-amqp-common-go/v4/rpc"
+	"github.com/Azure/go-amqp"
+)
+
+const otherAudience = "amqps://mynamespace.servicebus.windows.net/otherqueue"
+
+func accepted() *amqp.Message {
+	return putTokenResponse(http.StatusAccepted, "Accepted")
+}
+
+func TestNegotiatorReusesLink(t *testing.T) {
+	token := testToken()
+	cbs := withFakeCBS(t,
+		putTokenExchanges(t, token, testAudience, accepted(), accepted()),
+		putTokenExchanges(t, token, otherAudience, accepted()))
+
+	n, err := NewNegotiator(nil)
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	require.NoError(t, n.NegotiateClaim(ctx, testAudience, staticProvider{token}))
+	require.NoError(t, n.NegotiateClaim(ctx, otherAudience, staticProvider{token}))
+	require.NoError(t, n.NegotiateClaim(ctx, testAudience, staticProvider{token}))
+	require.Len(t, cbs.opened(), 1)
+
+	require.NoError(t, n.Close(ctx))
+	require.ErrorIs(t, n.NegotiateClaim(ctx, testAudience, staticProvider{token}), ErrNegotiatorClosed)
+}
+
+func TestNegotiatorReopensDetachedLink(t *testing.T) {
+	token := testToken()
+	cbs := withFakeCBS(t, putTokenExchanges(t, token, testAudience, accepted(), accepted()))
+
+	n, err := NewNegotiator(nil)
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	defer func() { require.NoError(t, n.Close(ctx)) }()
+
+	require.NoError(t, n.NegotiateClaim(ctx, testAudience, staticProvider{token}))
+
+	// the link is detached underneath the Negotiator, so the next negotiation puts its token on a new link
+	require.NoError(t, cbs.opened()[0].Close(ctx))
+	require.NoError(t, n.NegotiateClaim(ctx, testAudience, staticProvider{token}))
+	require.Len(t, cbs.opened(), 2)
+}
+
+func TestNegotiatorRetriesDetachOnce(t *testing.T) {
+	token := testToken()
+	cbs := withFakeCBS(t, putTokenExchanges(t, token, testAudience, accepted()))
+
+	n, err := NewNegotiator(nil)
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	defer func() { require.NoError(t, n.Close(ctx)) }()
+
+	require.NoError(t, n.NegotiateClaim(ctx, testAudience, staticProvider{token}))
+
+	// every link the Negotiator opens from now on is detached before it is used
+	cbs.mu.Lock()
+	cbs.detach = true
+	cbs.mu.Unlock()
+	require.NoError(t, cbs.opened()[0].Close(ctx))
+
+	err = n.NegotiateClaim(ctx, testAudience, staticProvider{token})
+	require.True(t, rpc.IsDetached(err), "a second detach is reported: %v", err)
+	require.Len(t, cbs.opened(), 2)
+}
+
+func TestNegotiatorOpensLinkOutsideLock(t *testing.T) {
+	token := testToken()
+	cbs := withFakeCBS(t, putTokenExchanges(t, token, testAudience, accepted(), accepted(), accepted()))
+	attaching := make(chan struct{})
+	attached := make(chan struct{})
+	var once sync.Once
+	cbs.attach = func() error {
+		once.Do(func() { close(attaching) })
+		<-attached
+		return nil
+	}
+
+	n, err := NewNegotiator(nil)
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	errs := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			errs <- n.NegotiateClaim(ctx, testAudience, staticProvider{token})
+		}()
+	}
+	<-attaching
+
+	// the Negotiator can be closed while a link is being opened
+	require.NoError(t, n.Close(ctx))
+	close(attached)
+
+	for i := 0; i < 3; i++ {
+		require.ErrorIs(t, <-errs, ErrNegotiatorClosed)
+	}
+	require.Len(t, cbs.opened(), 1, "concurrent negotiations wait for the link being opened")
+	_, err = cbs.opened()[0].RPC(ctx, &amqp.Message{})
+	require.ErrorIs(t, err, rpc.ErrLinkClosed, "a link opened after Close is closed")
+}
+
+func TestNegotiateClaimsReportsEachAudience(t *testing.T) {
+	token := testToken()
+	withFakeCBS(t,
+		putTokenExchanges(t, token, testAudience, accepted()),
+		putTokenExchanges(t, token, otherAudience, putTokenResponse(http.StatusUnauthorized, "denied")))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	results, err := NegotiateClaims(ctx, nil, staticProvider{token}, testAudience, otherAudience)
+	require.ErrorContains(t, err, "1 of 2 audiences")
+	require.True(t, IsUnauthorized(err))
+	require.Len(t, results, 2)
+
+	expiry, err := parseExpiry(token.Expiry)
+	require.NoError(t, err)
+	require.Equal(t, ClaimResult{Audience: testAudience, Expiry: expiry}, results[0])
+
+	require.Equal(t, otherAudience, results[1].Audience)
+	require.Zero(t, results[1].Expiry)
+	require.True(t, IsUnauthorized(results[1].Err))
+}
+
+// blockingProvider signals waiting for every call and returns token once release is closed, tracking how many calls
+// are in progress at once
+type blockingProvider struct {
+	token   *auth.Token
+	waiting chan struct{}
+	release chan struct{}
+
+	mu     sync.Mutex
+	active int
+	peak   int
+}
+
+func (p *blockingProvider) GetToken(string) (*auth.Token, error) {
+	p.mu.Lock()
+	p.active++
+	if p.active > p.peak {
+		p.peak = p.active
+	}
+	p.mu.Unlock()
+
+	p.waiting <- struct{}{}
+	<-p.release
+
+	p.mu.Lock()
+	p.active--
+	p.mu.Unlock()
+	return p.token, nil
+}
+
+func (p *blockingProvider) inFlight() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.active
+}
+
+func TestNegotiateClaimsBoundsConcurrency(t *testing.T) {
+	token := testToken()
+	responses := map[string]*amqp.Message{
+		"amqps://ns.servicebus.windows.net/a": accepted(),
+		"amqps://ns.servicebus.windows.net/b": putTokenResponse(http.StatusUnauthorized, "denied"),
+		"amqps://ns.servicebus.windows.net/c": accepted(),
+		"amqps://ns.servicebus.windows.net/d": putTokenResponse(http.StatusNotFound, "no such entity"),
+		"amqps://ns.servicebus.windows.net/e": accepted(),
+		"amqps://ns.servicebus.windows.net/f": accepted(),
+	}
+	audiences := []string{
+		"amqps://ns.servicebus.windows.net/a",
+		"amqps://ns.servicebus.windows.net/b",
+		"amqps://ns.servicebus.windows.net/c",
+		"amqps://ns.servicebus.windows.net/d",
+		"amqps://ns.servicebus.windows.net/e",
+		"amqps://ns.servicebus.windows.net/f",
+	}
+
+	var exchanges [][]rpc.Exchange
+	for _, audience := range audiences {
+		exchanges = append(exchanges, putTokenExchanges(t, token, audience, responses[audience]))
+	}
+	cbs := withFakeCBS(t, exchanges...)
+
+	n, err := NewNegotiator(nil, NegotiatorWithMaxConcurrency(2))
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	defer func() { require.NoError(t, n.Close(ctx)) }()
+
+	provider := &blockingProvider{
+		token:   token,
+		waiting: make(chan struct{}, len(audiences)),
+		release: make(chan struct{}),
+	}
+
+	var results []ClaimResult
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		results, err = n.NegotiateClaims(ctx, provider, audiences...)
+	}()
+
+	// exactly two requests are let through while they are held up
+	for i := 0; i < 2; i++ {
+		<-provider.waiting
+	}
+	select {
+	case <-provider.waiting:
+		t.Fatal("more than 2 put-token requests are in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+	require.Equal(t, 2, provider.inFlight())
+
+	close(provider.release)
+	<-done
+	require.ErrorContains(t, err, "2 of 6 audiences")
+	require.Equal(t, 2, provider.peak)
+	require.Len(t, cbs.opened(), 1)
+
+	expiry, err := parseExpiry(token.Expiry)
+	require.NoError(t, err)
+	for i, result := range results {
+		require.Equal(t, audiences[i], result.Audience)
+		switch result.Audience {
+		case audiences[1]:
+			require.True(t, IsUnauthorized(result.Err))
+			require.Zero(t, result.Expiry)
+		case audiences[3]:
+			require.True(t, IsNotFound(result.Err))
+			require.Zero(t, result.Expiry)
+		default:
+			require.NoError(t, result.Err)
+			require.Equal(t, expiry, result.Expiry)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package cbs
+
+//	MIT License
+//
+//	Copyright (c) Microsoft Corporation. All rights reserved.
+//
+//	Permission is hereby granted, free of charge, to any person obtaining a copy
+//	of this software and associated documentation files (the "Software"), to deal
+//	in the Software without restriction, including without limitation the rights
+//	to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//	copies of the Software, and to permit persons to whom the Software is
+//	furnished to do so, subject to the following conditions:
+//
+//	The above copyright notice and this permission notice shall be included in all
+//	copies or substantial portions of the Software.
+//
+//	THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//	AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//	LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//	OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//	SOFTWARE
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Azure/azure-amqp-common-go/v4/auth"
+)
+
+type (
+	// ClaimEvent describes a claim negotiation. Fields which are not known yet when the event is raised are left at
+	// their zero value; for instance TokenType and Expiry are empty in the start event and in failure events raised
+	// before a token could be fetched.
+	ClaimEvent struct {
+		// Audience is the audience the claim is negotiated for
+		Audience string
+		// TokenType is the type of the token which was put
+		TokenType auth.TokenType
+		// Expiry is the expiry of the token which was put
+		Expiry time.Time
+		// Started is when the negotiation started
+		Started time.Time
+		// Duration is how long the negotiation took, including retries
+		Duration time.Duration
+		// Attempts is the number of put-token requests which were sent
+		Attempts int
+		// StatusCode is the status code of the last put-token response, or zero if none was received
+		StatusCode int
+		// Err is the error which caused the negotiation to fail
+		Err error
+	}
+
+	// Observer receives an event when a claim negotiation starts and when it succeeds or fails. Observers are called
+	// synchronously from the negotiating goroutine and should not block.
+	Observer interface {
+		OnClaimStart(ctx context.Context, event ClaimEvent)
+		OnClaimSuccess(ctx context.Context, event ClaimEvent)
+		OnClaimFailure(ctx context.Context, event ClaimEvent)
+	}
+
+	// ObserverFuncs implements Observer with optional functions, so only the events of interest need to be handled
+	ObserverFuncs struct {
+		Start   func(ctx context.Context, event ClaimEvent)
+		Success func(ctx context.Context, event ClaimEvent)
+		Failure func(ctx context.Context, event ClaimEvent)
+	}
+
+	// observers fans events out to each of the configured observers
+	observers []Observer
+)
+
+// ClaimWithObserver adds an Observer which is notified of the negotiation
+func ClaimWithObserver(observer Observer) ClaimOption {
+	return func(c *claimConfig) error {
+		if observer == nil {
+			return errors.New("observer must not be nil")
+		}
+		c.observers = append(c.observers, observer)
+		return nil
+	}
+}
+
+// OnClaimStart implements Observer
+func (o ObserverFuncs) OnClaimStart(ctx context.Context, event ClaimEvent) {
+	if o.Start != nil {
+		o.Start(ctx, event)
+	}
+}
+
+// OnClaimSuccess implements Observer
+func (o ObserverFuncs) OnClaimSuccess(ctx context.Context, event ClaimEvent) {
+	if o.Success != nil {
+		o.Success(ctx, event)
+	}
+}
+
+// OnClaimFailure implements Observer
+func (o ObserverFuncs) OnClaimFailure(ctx context.Context, event ClaimEvent) {
+	if o.Failure != nil {
+		o.Failure(ctx, event)
+	}
+}
+
+func (o observers) start(ctx context.Context, event ClaimEvent) {
+	for _, observer := range o {
+		observer.OnClaimStart(ctx, event)
+	}
+}
+
+// finish raises a success or failure event, depending on event.Err
+func (o observers) finish(ctx context.Context, event ClaimEvent) {
+	event.Duration = time.Since(event.Started)
+	for _, observer := range o {
+		if event.Err != nil {
+			observer.OnClaimFailure(ctx, event)
+		} else {
+			observer.OnClaimSuccess(ctx, event)
+		}
+	}
+}
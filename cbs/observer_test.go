@@ -0,0 +1,121 @@
+package cbs
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/Azure/azure-amqp-common-go/v4/auth"
+	"github.com/Azure/azure-amqp-common-go/v4/rpc"
+)
+
+func TestObserversFinish(t *testing.T) {
+	var successes, failures []ClaimEvent
+	o := observers{
+		ObserverFuncs{
+			Success: func(ctx context.Context, event ClaimEvent) { successes = append(successes, event) },
+			Failure: func(ctx context.Context, event ClaimEvent) { failures = append(failures, event) },
+		},
+		// observers which only handle some events must not panic on the others
+		ObserverFuncs{},
+	}
+
+	started := time.Now().Add(-time.Second)
+	o.start(context.Background(), ClaimEvent{Audience: "aud", Started: started})
+	o.finish(context.Background(), ClaimEvent{Audience: "aud", Started: started, Attempts: 1, StatusCode: 202})
+	o.finish(context.Background(), ClaimEvent{Audience: "aud", Started: started, Attempts: 3, Err: errors.New("boom")})
+
+	require.Len(t, successes, 1)
+	require.Len(t, failures, 1)
+	require.GreaterOrEqual(t, successes[0].Duration, time.Second)
+	require.Equal(t, 202, successes[0].StatusCode)
+	require.Equal(t, 3, failures[0].Attempts)
+	require.EqualError(t, failures[0].Err, "boom")
+}
+
+func TestClaimWithObserver(t *testing.T) {
+	cfg, err := newClaimConfig(ClaimWithObserver(ObserverFuncs{}), ClaimWithObserver(ObserverFuncs{}))
+	require.NoError(t, err)
+	require.Len(t, cfg.observers, 2)
+
+	_, err = newClaimConfig(ClaimWithObserver(nil))
+	require.Error(t, err)
+}
+
+// recordingObserver collects the events it receives
+type recordingObserver struct {
+	starts, successes, failures []ClaimEvent
+}
+
+func (o *recordingObserver) OnClaimStart(ctx context.Context, event ClaimEvent) {
+	o.starts = append(o.starts, event)
+}
+
+func (o *recordingObserver) OnClaimSuccess(ctx context.Context, event ClaimEvent) {
+	o.successes = append(o.successes, event)
+}
+
+func (o *recordingObserver) OnClaimFailure(ctx context.Context, event ClaimEvent) {
+	o.failures = append(o.failures, event)
+}
+
+func TestObserverSeesNegotiation(t *testing.T) {
+	token := testToken()
+	expiry, err := parseExpiry(token.Expiry)
+	require.NoError(t, err)
+	withFakeCBS(t, putTokenExchanges(t, token, testAudience,
+		putTokenResponse(http.StatusServiceUnavailable, "try again"),
+		putTokenResponse(http.StatusAccepted, "Accepted")))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	observer := &recordingObserver{}
+	err = NegotiateClaimWithOptions(ctx, testAudience, nil, staticProvider{token},
+		ClaimWithRetryPolicy(rpc.FixedRetryPolicy{MaxAttempts: 3, Delay: time.Millisecond}),
+		ClaimWithObserver(observer))
+	require.NoError(t, err)
+
+	require.Len(t, observer.starts, 1)
+	require.Empty(t, observer.failures)
+	require.Len(t, observer.successes, 1)
+
+	event := observer.successes[0]
+	require.Equal(t, testAudience, event.Audience)
+	require.Equal(t, auth.CBSTokenTypeJWT, event.TokenType)
+	require.Equal(t, expiry, event.Expiry)
+	require.Equal(t, 2, event.Attempts)
+	require.Equal(t, http.StatusAccepted, event.StatusCode)
+	require.NoError(t, event.Err)
+}
+
+func TestObserverSeesRetriedFailure(t *testing.T) {
+	token := testToken()
+	withFakeCBS(t, putTokenExchanges(t, token, testAudience,
+		putTokenResponse(http.StatusServiceUnavailable, "try again"),
+		putTokenResponse(http.StatusInternalServerError, "still failing")))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	observer := &recordingObserver{}
+	err := NegotiateClaimWithOptions(ctx, testAudience, nil, staticProvider{token},
+		ClaimWithRetryPolicy(rpc.FixedRetryPolicy{MaxAttempts: 2, Delay: time.Millisecond}),
+		ClaimWithObserver(observer))
+	require.Error(t, err)
+
+	require.Len(t, observer.starts, 1)
+	require.Empty(t, observer.successes)
+	require.Len(t, observer.failures, 1)
+
+	event := observer.failures[0]
+	require.Equal(t, auth.CBSTokenTypeJWT, event.TokenType)
+	require.Equal(t, 2, event.Attempts)
+	require.Equal(t, http.StatusInternalServerError, event.StatusCode)
+	require.True(t, IsRetryable(event.Err))
+	require.ErrorIs(t, event.Err, err)
+}
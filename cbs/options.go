@@ -0,0 +1,96 @@
+package cbs
+
+//	MIT License
+//
+//	Copyright (c) Microsoft Corporation. All rights reserved.
+//
+//	Permission is hereby granted, free of charge, to any person obtaining a copy
+//	of this software and associated documentation files (the "Software"), to deal
+//	in the Software without restriction, including without limitation the rights
+//	to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//	copies of the Software, and to permit persons to whom the Software is
+//	furnished to do so, subject to the following conditions:
+//
+//	The above copyright notice and this permission notice shall be included in all
+//	copies or substantial portions of the Software.
+//
+//	THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//	AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//	LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//	OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//	SOFTWARE
+
+import (
+	"errors"
+	"time"
+
+	"github.com/Azure/azure-amqp-common-go/v4/rpc"
+)
+
+const (
+	defaultPutTokenAttempts = 3
+	defaultPutTokenDelay    = 1 * time.Second
+)
+
+type (
+	// ClaimOption provides a way to customize how a claim is negotiated
+	ClaimOption func(c *claimConfig) error
+
+	claimConfig struct {
+		retryPolicy    rpc.RetryPolicy
+		attemptTimeout time.Duration
+		serverTimeout  time.Duration
+		observers      observers
+		registry       *Registry
+	}
+)
+
+// ClaimWithRetryPolicy configures when, and after how long, a put-token request is retried after a retryable
+// failure. The default makes up to 3 attempts, one second apart.
+func ClaimWithRetryPolicy(policy rpc.RetryPolicy) ClaimOption {
+	return func(c *claimConfig) error {
+		if policy == nil {
+			return errors.New("retry policy must not be nil")
+		}
+		c.retryPolicy = policy
+		return nil
+	}
+}
+
+// ClaimWithAttemptTimeout bounds how long each put-token attempt may take. An attempt which times out is retried.
+func ClaimWithAttemptTimeout(timeout time.Duration) ClaimOption {
+	return func(c *claimConfig) error {
+		if timeout <= 0 {
+			return errors.New("attempt timeout must be positive")
+		}
+		c.attemptTimeout = timeout
+		return nil
+	}
+}
+
+// ClaimWithServerTimeout configures the server-timeout sent to the broker with each put-token request. By default the
+// server-timeout is derived from the deadline of the context, if there is one.
+func ClaimWithServerTimeout(timeout time.Duration) ClaimOption {
+	return func(c *claimConfig) error {
+		if timeout <= 0 {
+			return errors.New("server timeout must be positive")
+		}
+		c.serverTimeout = timeout
+		return nil
+	}
+}
+
+func newClaimConfig(opts ...ClaimOption) (*claimConfig, error) {
+	c := &claimConfig{
+		retryPolicy: rpc.FixedRetryPolicy{MaxAttempts: defaultPutTokenAttempts, Delay: defaultPutTokenDelay},
+	}
+
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
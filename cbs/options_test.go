@@ -0,0 +1,32 @@
+package cbs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/Azure/azure-amqp-common-go/v4/rpc"
+)
+
+func TestClaimConfig(t *testing.T) {
+	cfg, err := newClaimConfig()
+	require.NoError(t, err)
+	require.Equal(t, rpc.FixedRetryPolicy{MaxAttempts: defaultPutTokenAttempts, Delay: defaultPutTokenDelay}, cfg.retryPolicy)
+	require.Zero(t, cfg.attemptTimeout)
+	require.Zero(t, cfg.serverTimeout)
+
+	cfg, err = newClaimConfig(
+		ClaimWithRetryPolicy(rpc.ExponentialRetryPolicy{MaxAttempts: 5, BaseDelay: 10 * time.Millisecond}),
+		ClaimWithAttemptTimeout(time.Second),
+		ClaimWithServerTimeout(2*time.Second))
+	require.NoError(t, err)
+	require.Equal(t, rpc.ExponentialRetryPolicy{MaxAttempts: 5, BaseDelay: 10 * time.Millisecond}, cfg.retryPolicy)
+	require.Equal(t, time.Second, cfg.attemptTimeout)
+	require.Equal(t, 2*time.Second, cfg.serverTimeout)
+
+	_, err = newClaimConfig(ClaimWithRetryPolicy(nil))
+	require.Error(t, err)
+	_, err = newClaimConfig(ClaimWithAttemptTimeout(0))
+	require.Error(t, err)
+}
@@ -0,0 +1,348 @@
+package cbs
+
+//	MIT License
+//
+//	Copyright (c) Microsoft Corporation. All rights reserved.
+//
+//	Permission is hereby granted, free of charge, to any person obtaining a copy
+//	of this software and associated documentation files (the "Software"), to deal
+//	in the Software without restriction, including without limitation the rights
+//	to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//	copies of the Software, and to permit persons to whom the Software is
+//	furnished to do so, subject to the following conditions:
+//
+//	The above copyright notice and this permission notice shall be included in all
+//	copies or substantial portions of the Software.
+//
+//	THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//	AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//	LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//	OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//	SOFTWARE
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/devigned/tab"
+
+	"github.com/Azure/azure-amqp-common-go/v4/auth"
+	"github.com/Azure/azure-amqp-common-go/v4/internal/tracing"
+	"github.com/Azure/go-amqp"
+)
+
+const (
+	defaultRefreshMargin     = 5 * time.Minute
+	defaultRefreshMinBackoff = 1 * time.Second
+	defaultRefreshMaxBackoff = 1 * time.Minute
+	defaultRefreshTimeout    = 30 * time.Second
+)
+
+type (
+	// Refresher keeps the claim for a single audience on an AMQP connection alive by negotiating a new token a
+	// margin before the current one expires
+	Refresher struct {
+		conn      *amqp.Conn
+		audience  string
+		provider  auth.TokenProvider
+		claimOpts []ClaimOption
+
+		margin     time.Duration
+		minBackoff time.Duration
+		maxBackoff time.Duration
+		timeout    time.Duration
+		onError    func(error)
+		lifetime   context.Context
+
+		mu       sync.Mutex
+		cancel   context.CancelFunc
+		done     chan struct{}
+		expiry   time.Time
+		starting bool
+		stopped  bool
+		handling bool
+
+		// for unit tests
+		negotiate func(ctx context.Context) (*auth.Token, error)
+		now       func() time.Time
+		after     func(d time.Duration) <-chan time.Time
+	}
+
+	// RefresherOption provides a way to customize the construction of a Refresher
+	RefresherOption func(r *Refresher) error
+)
+
+// RefresherWithMargin configures how long before the expiry of a token the claim is re-negotiated
+func RefresherWithMargin(margin time.Duration) RefresherOption {
+	return func(r *Refresher) error {
+		if margin < 0 {
+			return errors.New("refresh margin must not be negative")
+		}
+		r.margin = margin
+		return nil
+	}
+}
+
+// RefresherWithBackoff configures the delay between failed negotiation attempts. The delay starts at min and doubles
+// after each consecutive failure until it reaches max. Renewals are never scheduled sooner than min, even for tokens
+// which expire within the refresh margin.
+func RefresherWithBackoff(min, max time.Duration) RefresherOption {
+	return func(r *Refresher) error {
+		if min <= 0 || max < min {
+			return fmt.Errorf("invalid refresh backoff: min %s, max %s", min, max)
+		}
+		r.minBackoff = min
+		r.maxBackoff = max
+		return nil
+	}
+}
+
+// RefresherWithTimeout configures how long a single background negotiation may take
+func RefresherWithTimeout(timeout time.Duration) RefresherOption {
+	return func(r *Refresher) error {
+		if timeout <= 0 {
+			return errors.New("refresh timeout must be positive")
+		}
+		r.timeout = timeout
+		return nil
+	}
+}
+
+// RefresherWithErrorHandler configures a callback which is invoked each time a background negotiation fails. The
+// callback is invoked from the refresh goroutine and should not block. It may call Stop, for instance to give up on
+// an unauthorized error; no further renewal is attempted once it returns.
+func RefresherWithErrorHandler(handler func(err error)) RefresherOption {
+	return func(r *Refresher) error {
+		r.onError = handler
+		return nil
+	}
+}
+
+// RefresherWithContext bounds the lifetime of the background renewal: once ctx is done, the claim is no longer renewed,
+// as if Stop had been called. Unlike the context passed to Start, which only bounds the first negotiation, ctx is
+// typically that of the connection or the application.
+func RefresherWithContext(ctx context.Context) RefresherOption {
+	return func(r *Refresher) error {
+		if ctx == nil {
+			return errors.New("refresher context must not be nil")
+		}
+		r.lifetime = ctx
+		return nil
+	}
+}
+
+// RefresherWithClaimOptions configures the retry and timeout policy of each negotiation
+func RefresherWithClaimOptions(opts ...ClaimOption) RefresherOption {
+	return func(r *Refresher) error {
+		if _, err := newClaimConfig(opts...); err != nil {
+			return err
+		}
+		r.claimOpts = append(r.claimOpts, opts...)
+		return nil
+	}
+}
+
+// NewRefresher builds a Refresher which keeps the claim for audience on conn alive using tokens from provider
+func NewRefresher(conn *amqp.Conn, audience string, provider auth.TokenProvider, opts ...RefresherOption) (*Refresher, error) {
+	r := &Refresher{
+		conn:       conn,
+		audience:   audience,
+		provider:   provider,
+		margin:     defaultRefreshMargin,
+		minBackoff: defaultRefreshMinBackoff,
+		maxBackoff: defaultRefreshMaxBackoff,
+		timeout:    defaultRefreshTimeout,
+		now:        time.Now,
+		after:      time.After,
+	}
+	r.negotiate = func(ctx context.Context) (*auth.Token, error) {
+		return negotiateClaim(ctx, r.audience, conn, r.provider, r.claimOpts...)
+	}
+
+	for _, opt := range opts {
+		if err := opt(r); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Start negotiates the claim and, if successful, starts renewing it in the background. ctx bounds the first
+// negotiation only; the background renewal runs until Stop is called or the context configured with
+// RefresherWithContext is done.
+func (r *Refresher) Start(ctx context.Context) error {
+	ctx, span := tracing.StartSpanFromContext(ctx, "az-amqp-common.cbs.Refresher.Start")
+	defer span.End()
+
+	r.mu.Lock()
+	if r.stopped {
+		r.mu.Unlock()
+		return errors.New("refresher has been stopped")
+	}
+	if r.done != nil || r.starting {
+		r.mu.Unlock()
+		return errors.New("refresher has already been started")
+	}
+	r.starting = true
+	r.mu.Unlock()
+
+	expiry, err := r.refresh(ctx)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.starting = false
+
+	if err != nil {
+		tab.For(ctx).Error(err)
+		return err
+	}
+
+	if r.stopped {
+		return errors.New("refresher has been stopped")
+	}
+
+	cfg, err := newClaimConfig(r.claimOpts...)
+	if err != nil {
+		return err
+	}
+
+	lifetime := r.lifetime
+	if lifetime == nil {
+		lifetime = context.Background()
+	}
+	loopCtx, cancel := context.WithCancel(lifetime)
+	r.cancel = cancel
+	r.done = make(chan struct{})
+	go r.run(loopCtx, expiry, cfg.registry)
+	return nil
+}
+
+// Stop halts the background renewal and waits for it to finish. If the error handler is running, such as when Stop is
+// called from the handler itself, Stop returns without waiting for it. It is safe to call Stop more than once.
+func (r *Refresher) Stop() {
+	r.mu.Lock()
+	r.stopped = true
+	cancel, done, handling := r.cancel, r.done, r.handling
+	r.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+		if !handling {
+			<-done
+		}
+	}
+}
+
+// Expiry returns the expiry of the most recently negotiated token
+func (r *Refresher) Expiry() time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.expiry
+}
+
+func (r *Refresher) run(ctx context.Context, expiry time.Time, registry *Registry) {
+	defer close(r.done)
+
+	if registry != nil {
+		defer registry.setNextRenewal(r.conn, r.audience, time.Time{})
+	}
+
+	backoff := time.Duration(0)
+	for {
+		wait := expiry.Add(-r.margin).Sub(r.now())
+		if backoff > 0 {
+			wait = backoff
+		}
+		if wait < r.minBackoff {
+			// a token which is already within the margin is renewed soon, but not in a tight loop
+			wait = r.minBackoff
+		}
+
+		if registry != nil {
+			registry.setNextRenewal(r.conn, r.audience, r.now().Add(wait))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.after(wait):
+		}
+
+		attemptCtx, cancel := context.WithTimeout(ctx, r.timeout)
+		next, err := r.refresh(attemptCtx)
+		cancel()
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			tab.For(ctx).Error(err)
+			if r.onError != nil {
+				r.mu.Lock()
+				r.handling = true
+				r.mu.Unlock()
+
+				r.onError(err)
+
+				r.mu.Lock()
+				r.handling = false
+				r.mu.Unlock()
+
+				if ctx.Err() != nil {
+					// the handler stopped the refresher
+					return
+				}
+			}
+
+			backoff = nextBackoff(backoff, r.minBackoff, r.maxBackoff)
+			continue
+		}
+
+		backoff = 0
+		expiry = next
+	}
+}
+
+func (r *Refresher) refresh(ctx context.Context) (time.Time, error) {
+	token, err := r.negotiate(ctx)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to refresh claim for audience %s: %w", r.audience, err)
+	}
+
+	expiry, err := parseExpiry(token.Expiry)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	r.mu.Lock()
+	r.expiry = expiry
+	r.mu.Unlock()
+	return expiry, nil
+}
+
+// nextBackoff doubles the current backoff, keeping it within [min, max]
+func nextBackoff(current, min, max time.Duration) time.Duration {
+	if current < min {
+		return min
+	}
+	if current*2 > max {
+		return max
+	}
+	return current * 2
+}
+
+// parseExpiry converts the expiry of a token, expressed as seconds since the Unix epoch, to a time.Time
+func parseExpiry(expiry string) (time.Time, error) {
+	seconds, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("token expiry %q is not a unix timestamp: %w", expiry, err)
+	}
+	return time.Unix(seconds, 0), nil
+}
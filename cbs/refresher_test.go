@@ -0,0 +1,306 @@
+package cbs
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/Azure/azure-amqp-common-go/v4/auth"
+)
+
+func TestRefresherRenewsBeforeExpiry(t *testing.T) {
+	now := time.Unix(1000, 0)
+	var waits []time.Duration
+	renewed := make(chan struct{}, 10)
+	calls := 0
+
+	r := &Refresher{
+		audience:   "amqps://ns.servicebus.windows.net/queue",
+		margin:     time.Minute,
+		minBackoff: time.Second,
+		maxBackoff: time.Minute,
+		timeout:    time.Second,
+		negotiate: func(ctx context.Context) (*auth.Token, error) {
+			calls++
+			renewed <- struct{}{}
+			return tokenExpiringAt(now.Add(time.Hour)), nil
+		},
+		now: func() time.Time { return now },
+		after: func(d time.Duration) <-chan time.Time {
+			waits = append(waits, d)
+			ch := make(chan time.Time, 1)
+			if len(waits) < 3 {
+				ch <- now
+			}
+			return ch
+		},
+	}
+
+	require.NoError(t, r.Start(context.Background()))
+	for i := 0; i < 3; i++ {
+		<-renewed
+	}
+	r.Stop()
+
+	require.Equal(t, 3, calls)
+	require.Equal(t, []time.Duration{59 * time.Minute, 59 * time.Minute, 59 * time.Minute}, waits)
+	require.Equal(t, now.Add(time.Hour), r.Expiry())
+}
+
+func TestRefresherBacksOffAndReportsErrors(t *testing.T) {
+	now := time.Unix(1000, 0)
+	var mu sync.Mutex
+	var waits []time.Duration
+	var reported []error
+	calls := 0
+
+	r := &Refresher{
+		audience:   "aud",
+		margin:     time.Minute,
+		minBackoff: time.Second,
+		maxBackoff: 3 * time.Second,
+		timeout:    time.Second,
+		onError: func(err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			reported = append(reported, err)
+		},
+		negotiate: func(ctx context.Context) (*auth.Token, error) {
+			calls++
+			if calls == 1 {
+				return tokenExpiringAt(now.Add(time.Hour)), nil
+			}
+			return nil, errors.New("boom")
+		},
+		now: func() time.Time { return now },
+	}
+
+	stopAt := 5
+	stop := make(chan struct{})
+	r.after = func(d time.Duration) <-chan time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		waits = append(waits, d)
+		ch := make(chan time.Time, 1)
+		if len(waits) < stopAt {
+			ch <- now
+		} else {
+			close(stop)
+		}
+		return ch
+	}
+
+	require.NoError(t, r.Start(context.Background()))
+	<-stop
+	r.Stop()
+
+	require.Equal(t, []time.Duration{59 * time.Minute, time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second}, waits)
+	require.Len(t, reported, 4)
+	require.Contains(t, reported[0].Error(), "boom")
+}
+
+func TestRefresherStartFailure(t *testing.T) {
+	r := &Refresher{
+		audience: "aud",
+		negotiate: func(ctx context.Context) (*auth.Token, error) {
+			return nil, errors.New("boom")
+		},
+	}
+
+	require.Error(t, r.Start(context.Background()))
+	// stopping a refresher which never started must not block
+	r.Stop()
+}
+
+func TestRefresherStartsOnce(t *testing.T) {
+	now := time.Unix(1000, 0)
+	negotiating := make(chan struct{}, 2)
+	release := make(chan struct{})
+
+	r := &Refresher{
+		audience:   "aud",
+		margin:     time.Minute,
+		minBackoff: time.Second,
+		timeout:    time.Second,
+		negotiate: func(ctx context.Context) (*auth.Token, error) {
+			negotiating <- struct{}{}
+			<-release
+			return tokenExpiringAt(now.Add(time.Hour)), nil
+		},
+		now:   func() time.Time { return now },
+		after: func(d time.Duration) <-chan time.Time { return make(chan time.Time) },
+	}
+
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			errs <- r.Start(context.Background())
+		}()
+	}
+
+	// one Start negotiates while the other is refused
+	<-negotiating
+	require.ErrorContains(t, <-errs, "already been started")
+	close(release)
+	require.NoError(t, <-errs)
+	require.Empty(t, negotiating)
+
+	r.Stop()
+}
+
+func TestRefresherRenewsExpiringTokensWithoutSpinning(t *testing.T) {
+	now := time.Unix(1000, 0)
+	waited := make(chan time.Duration, 1)
+
+	r := &Refresher{
+		audience:   "aud",
+		margin:     5 * time.Minute,
+		minBackoff: time.Second,
+		timeout:    time.Second,
+		negotiate: func(ctx context.Context) (*auth.Token, error) {
+			// the token expires within the refresh margin
+			return tokenExpiringAt(now.Add(time.Minute)), nil
+		},
+		now: func() time.Time { return now },
+		after: func(d time.Duration) <-chan time.Time {
+			waited <- d
+			return make(chan time.Time)
+		},
+	}
+
+	require.NoError(t, r.Start(context.Background()))
+	require.Equal(t, time.Second, <-waited)
+	r.Stop()
+}
+
+func TestRefresherOutlivesStartContext(t *testing.T) {
+	now := time.Unix(1000, 0)
+	renewed := make(chan struct{}, 10)
+	fire := make(chan time.Time, 1)
+
+	r := &Refresher{
+		audience:   "aud",
+		margin:     time.Minute,
+		minBackoff: time.Second,
+		timeout:    time.Second,
+		negotiate: func(ctx context.Context) (*auth.Token, error) {
+			renewed <- struct{}{}
+			return tokenExpiringAt(now.Add(time.Hour)), nil
+		},
+		now:   func() time.Time { return now },
+		after: func(d time.Duration) <-chan time.Time { return fire },
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	require.NoError(t, r.Start(ctx))
+	<-renewed
+	cancel()
+
+	// renewal goes on after the context passed to Start is done
+	fire <- now
+	select {
+	case <-renewed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the claim was not renewed after the start context was cancelled")
+	}
+	r.Stop()
+}
+
+func TestRefresherStopsWithLifetimeContext(t *testing.T) {
+	now := time.Unix(1000, 0)
+	renewed := make(chan struct{}, 10)
+	fire := make(chan time.Time)
+
+	lifetime, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	r, err := NewRefresher(nil, "aud", nil, RefresherWithContext(lifetime))
+	require.NoError(t, err)
+	r.negotiate = func(ctx context.Context) (*auth.Token, error) {
+		renewed <- struct{}{}
+		return tokenExpiringAt(now.Add(time.Hour)), nil
+	}
+	r.now = func() time.Time { return now }
+	r.after = func(d time.Duration) <-chan time.Time { return fire }
+
+	require.NoError(t, r.Start(context.Background()))
+	<-renewed
+	cancel()
+
+	select {
+	case <-r.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("renewal went on after the lifetime context was cancelled")
+	}
+	require.Empty(t, renewed)
+	r.Stop()
+
+	_, err = NewRefresher(nil, "aud", nil, RefresherWithContext(nil))
+	require.Error(t, err)
+}
+
+func TestRefresherStopFromErrorHandler(t *testing.T) {
+	now := time.Unix(1000, 0)
+	calls := 0
+	stopped := make(chan struct{})
+
+	r := &Refresher{
+		audience:   "aud",
+		margin:     time.Minute,
+		minBackoff: time.Second,
+		maxBackoff: time.Minute,
+		timeout:    time.Second,
+		negotiate: func(ctx context.Context) (*auth.Token, error) {
+			calls++
+			if calls == 1 {
+				return tokenExpiringAt(now.Add(time.Hour)), nil
+			}
+			return nil, &ClaimError{Code: 401}
+		},
+		now: func() time.Time { return now },
+		after: func(d time.Duration) <-chan time.Time {
+			ch := make(chan time.Time, 1)
+			ch <- now
+			return ch
+		},
+	}
+	r.onError = func(err error) {
+		if IsUnauthorized(err) {
+			r.Stop()
+			close(stopped)
+		}
+	}
+
+	require.NoError(t, r.Start(context.Background()))
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop blocked when called from the error handler")
+	}
+
+	select {
+	case <-r.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the refresh goroutine did not exit")
+	}
+	require.Equal(t, 2, calls, "no renewal is attempted after the handler stops the refresher")
+	r.Stop()
+}
+
+func TestParseExpiry(t *testing.T) {
+	expiry, err := parseExpiry("1700000000")
+	require.NoError(t, err)
+	require.Equal(t, time.Unix(1700000000, 0), expiry)
+
+	_, err = parseExpiry("tomorrow")
+	require.Error(t, err)
+}
+
+func tokenExpiringAt(expiry time.Time) *auth.Token {
+	return auth.NewToken(auth.CBSTokenTypeSAS, "token", strconv.FormatInt(expiry.Unix(), 10))
+}
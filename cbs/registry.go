@@ -0,0 +1,208 @@
+package cbs
+
+//	MIT License
+//
+//	Copyright (c) Microsoft Corporation. All rights reserved.
+//
+//	Permission is hereby granted, free of charge, to any person obtaining a copy
+//	of this software and associated documentation files (the "Software"), to deal
+//	in the Software without restriction, including without limitation the rights
+//	to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//	copies of the Software, and to permit persons to whom the Software is
+//	furnished to do so, subject to the following conditions:
+//
+//	The above copyright notice and this permission notice shall be included in all
+//	copies or substantial portions of the Software.
+//
+//	THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//	AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//	LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//	OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//	SOFTWARE
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Azure/go-amqp"
+)
+
+type (
+	// ClaimStatus is the state of the claim for a single audience on a connection
+	ClaimStatus struct {
+		// Audience is the audience of the claim
+		Audience string
+		// Negotiating is true while any negotiation for the audience is in progress
+		Negotiating bool
+		// LastAttempt is when a negotiation for the audience last started
+		LastAttempt time.Time
+		// LastSuccess is when a negotiation for the audience last succeeded. It is zero if none has.
+		LastSuccess time.Time
+		// Expiry is the expiry of the token accepted by the last successful negotiation
+		Expiry time.Time
+		// LastError is the error of the most recent failed negotiation. It is kept after a later negotiation succeeds;
+		// compare LastErrorTime with LastSuccess to tell whether the claim is currently failing.
+		LastError error
+		// LastErrorTime is when LastError occurred
+		LastErrorTime time.Time
+		// NextRenewal is when a Refresher is next going to renew the claim. It is zero if no renewal is scheduled.
+		NextRenewal time.Time
+	}
+
+	// Registry tracks the state of claims per connection and audience. Negotiations record their outcome in a Registry
+	// configured through ClaimWithRegistry; Refreshers configured with that option also record when they next renew.
+	// Entries are kept until Forget is called for the connection; negotiations and renewals which finish after that
+	// do not record it again.
+	Registry struct {
+		mu     sync.RWMutex
+		claims map[*amqp.Conn]map[string]*claimEntry
+	}
+
+	// claimEntry is the status of a claim along with how many negotiations for it are in progress
+	claimEntry struct {
+		status       ClaimStatus
+		negotiations int
+	}
+
+	// registryObserver records claim events for a single connection into a Registry
+	registryObserver struct {
+		registry *Registry
+		conn     *amqp.Conn
+	}
+)
+
+// NewRegistry builds an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{
+		claims: map[*amqp.Conn]map[string]*claimEntry{},
+	}
+}
+
+// ClaimWithRegistry records the outcome of the negotiation in registry
+func ClaimWithRegistry(registry *Registry) ClaimOption {
+	return func(c *claimConfig) error {
+		if registry == nil {
+			return errors.New("registry must not be nil")
+		}
+		c.registry = registry
+		return nil
+	}
+}
+
+// Status returns the state of the claim for audience on conn. The second result is false if no claim for the
+// audience has been recorded.
+func (r *Registry) Status(conn *amqp.Conn, audience string) (ClaimStatus, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	entry, ok := r.claims[conn][audience]
+	if !ok {
+		return ClaimStatus{}, false
+	}
+	return entry.status, true
+}
+
+// Statuses returns the state of every claim recorded for conn, ordered by audience
+func (r *Registry) Statuses(conn *amqp.Conn) []ClaimStatus {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	statuses := make([]ClaimStatus, 0, len(r.claims[conn]))
+	for _, entry := range r.claims[conn] {
+		statuses = append(statuses, entry.status)
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Audience < statuses[j].Audience
+	})
+	return statuses
+}
+
+// Forget removes every claim recorded for conn. It should be called when the connection is closed.
+func (r *Registry) Forget(conn *amqp.Conn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.claims, conn)
+}
+
+func (r *Registry) observer(conn *amqp.Conn) Observer {
+	return &registryObserver{
+		registry: r,
+		conn:     conn,
+	}
+}
+
+// setNextRenewal records when the claim for audience on conn is next going to be renewed. It does nothing if the
+// claim is not recorded, for instance because the connection has been forgotten.
+func (r *Registry) setNextRenewal(conn *amqp.Conn, audience string, next time.Time) {
+	r.update(conn, audience, false, func(entry *claimEntry) {
+		entry.status.NextRenewal = next
+	})
+}
+
+// update applies fn to the entry for audience on conn. A missing entry is only created if create is true, so that
+// negotiations which finish after their connection has been forgotten do not record it again.
+func (r *Registry) update(conn *amqp.Conn, audience string, create bool, fn func(entry *claimEntry)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	audiences, ok := r.claims[conn]
+	if !ok {
+		if !create {
+			return
+		}
+		audiences = map[string]*claimEntry{}
+		r.claims[conn] = audiences
+	}
+
+	entry, ok := audiences[audience]
+	if !ok {
+		if !create {
+			return
+		}
+		entry = &claimEntry{status: ClaimStatus{Audience: audience}}
+		audiences[audience] = entry
+	}
+	fn(entry)
+}
+
+// finishNegotiation marks one of the negotiations of entry as no longer in progress
+func (e *claimEntry) finishNegotiation() {
+	if e.negotiations > 0 {
+		e.negotiations--
+	}
+	e.status.Negotiating = e.negotiations > 0
+}
+
+// OnClaimStart implements Observer
+func (o *registryObserver) OnClaimStart(_ context.Context, event ClaimEvent) {
+	o.registry.update(o.conn, event.Audience, true, func(entry *claimEntry) {
+		entry.negotiations++
+		entry.status.Negotiating = true
+		entry.status.LastAttempt = event.Started
+	})
+}
+
+// OnClaimSuccess implements Observer
+func (o *registryObserver) OnClaimSuccess(_ context.Context, event ClaimEvent) {
+	o.registry.update(o.conn, event.Audience, false, func(entry *claimEntry) {
+		entry.finishNegotiation()
+		entry.status.LastSuccess = event.Started.Add(event.Duration)
+		entry.status.Expiry = event.Expiry
+	})
+}
+
+// OnClaimFailure implements Observer
+func (o *registryObserver) OnClaimFailure(_ context.Context, event ClaimEvent) {
+	o.registry.update(o.conn, event.Audience, false, func(entry *claimEntry) {
+		entry.finishNegotiation()
+		entry.status.LastError = event.Err
+		entry.status.LastErrorTime = event.Started.Add(event.Duration)
+	})
+}
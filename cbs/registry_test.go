@@ -0,0 +1,140 @@
+package cbs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/Azure/azure-amqp-common-go/v4/auth"
+	"github.com/Azure/go-amqp"
+)
+
+func TestRegistryRecordsClaims(t *testing.T) {
+	registry := NewRegistry()
+	conn, otherConn := &amqp.Conn{}, &amqp.Conn{}
+	cfg, err := newClaimConfig(ClaimWithRegistry(registry))
+	require.NoError(t, err)
+
+	expiry := time.Now().Add(time.Hour).Truncate(time.Second)
+	_, err = observeClaim(context.Background(), conn, "b", cfg, func(event *ClaimEvent) (*auth.Token, error) {
+		status, ok := registry.Status(conn, "b")
+		require.True(t, ok)
+		require.True(t, status.Negotiating, "claim is negotiating until the negotiation finishes")
+
+		event.Expiry = expiry
+		return tokenExpiringAt(expiry), nil
+	})
+	require.NoError(t, err)
+
+	_, err = observeClaim(context.Background(), conn, "a", cfg, func(event *ClaimEvent) (*auth.Token, error) {
+		return nil, errors.New("boom")
+	})
+	require.Error(t, err)
+
+	statuses := registry.Statuses(conn)
+	require.Len(t, statuses, 2)
+
+	require.Equal(t, "a", statuses[0].Audience)
+	require.False(t, statuses[0].Negotiating)
+	require.True(t, statuses[0].LastSuccess.IsZero())
+	require.EqualError(t, statuses[0].LastError, "boom")
+	require.False(t, statuses[0].LastErrorTime.IsZero())
+
+	require.Equal(t, "b", statuses[1].Audience)
+	require.Equal(t, expiry, statuses[1].Expiry)
+	require.False(t, statuses[1].LastSuccess.IsZero())
+	require.NoError(t, statuses[1].LastError)
+
+	require.Empty(t, registry.Statuses(otherConn))
+	_, ok := registry.Status(otherConn, "a")
+	require.False(t, ok)
+
+	registry.Forget(conn)
+	require.Empty(t, registry.Statuses(conn))
+}
+
+func TestRegistryRecordsNextRenewal(t *testing.T) {
+	registry := NewRegistry()
+	conn := &amqp.Conn{}
+	now := time.Unix(1000, 0)
+	waiting := make(chan struct{})
+
+	cfg, err := newClaimConfig(ClaimWithRegistry(registry))
+	require.NoError(t, err)
+
+	r := &Refresher{
+		conn:      conn,
+		audience:  "aud",
+		claimOpts: []ClaimOption{ClaimWithRegistry(registry)},
+		margin:    time.Minute,
+		timeout:   time.Second,
+		negotiate: func(ctx context.Context) (*auth.Token, error) {
+			return observeClaim(ctx, conn, "aud", cfg, func(event *ClaimEvent) (*auth.Token, error) {
+				return tokenExpiringAt(now.Add(time.Hour)), nil
+			})
+		},
+		now: func() time.Time { return now },
+		after: func(d time.Duration) <-chan time.Time {
+			close(waiting)
+			return make(chan time.Time)
+		},
+	}
+
+	require.NoError(t, r.Start(context.Background()))
+	<-waiting
+
+	status, ok := registry.Status(conn, "aud")
+	require.True(t, ok)
+	require.Equal(t, now.Add(59*time.Minute), status.NextRenewal)
+
+	r.Stop()
+	status, _ = registry.Status(conn, "aud")
+	require.True(t, status.NextRenewal.IsZero(), "no renewal is scheduled once the refresher stops")
+}
+
+func TestRegistryDoesNotRecordForgottenConnections(t *testing.T) {
+	registry := NewRegistry()
+	conn := &amqp.Conn{}
+	cfg, err := newClaimConfig(ClaimWithRegistry(registry))
+	require.NoError(t, err)
+
+	_, err = observeClaim(context.Background(), conn, "aud", cfg, func(event *ClaimEvent) (*auth.Token, error) {
+		// the connection is closed while the claim is negotiated
+		registry.Forget(conn)
+		return tokenExpiringAt(time.Now().Add(time.Hour)), nil
+	})
+	require.NoError(t, err)
+	require.Empty(t, registry.Statuses(conn))
+
+	registry.setNextRenewal(conn, "aud", time.Now())
+	require.Empty(t, registry.Statuses(conn))
+}
+
+func TestRegistryCountsConcurrentNegotiations(t *testing.T) {
+	registry := NewRegistry()
+	conn := &amqp.Conn{}
+	cfg, err := newClaimConfig(ClaimWithRegistry(registry))
+	require.NoError(t, err)
+
+	negotiating := func() bool {
+		status, ok := registry.Status(conn, "aud")
+		require.True(t, ok)
+		return status.Negotiating
+	}
+
+	// a second negotiation starts and finishes while the first is in progress
+	_, err = observeClaim(context.Background(), conn, "aud", cfg, func(event *ClaimEvent) (*auth.Token, error) {
+		_, err := observeClaim(context.Background(), conn, "aud", cfg, func(event *ClaimEvent) (*auth.Token, error) {
+			return nil, errors.New("boom")
+		})
+		require.Error(t, err)
+		require.True(t, negotiating(), "the first negotiation is still in progress")
+
+		return tokenExpiringAt(time.Now().Add(time.Hour)), nil
+	})
+	require.NoError(t, err)
+	require.False(t, negotiating())
+}
@@ -0,0 +1,395 @@
+package cbs
+
+//	MIT License
+//
+//	Copyright (c) Microsoft Corporation. All rights reserved.
+//
+//	Permission is hereby granted, free of charge, to any person obtaining a copy
+//	of this software and associated documentation files (the "Software"), to deal
+//	in the Software without restriction, including without limitation the rights
+//	to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//	copies of the Software, and to permit persons to whom the Software is
+//	furnished to do so, subject to the following conditions:
+//
+//	The above copyright notice and this permission notice shall be included in all
+//	copies or substantial portions of the Software.
+//
+//	THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//	AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//	LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//	OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//	SOFTWARE
+
+import (
+	"context"
+	"crypto/hmac"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-amqp-common-go/v4/auth"
+	"github.com/Azure/azure-amqp-common-go/v4/conn"
+	"github.com/Azure/azure-amqp-common-go/v4/sas"
+	"github.com/Azure/go-amqp"
+)
+
+const (
+	statusCodeKey        = "status-code"
+	statusDescriptionKey = "status-description"
+	sasTokenPrefix       = "SharedAccessSignature "
+)
+
+type (
+	// Handler answers $cbs put-token requests the way Azure Service Bus and Event Hubs do. It is intended for local
+	// brokers and tests: SAS tokens are verified against the keys the Handler is configured with, other token types
+	// are checked by a TokenValidator, and every request is recorded so tests can assert on the claims which were
+	// negotiated.
+	Handler struct {
+		keys       map[string]string
+		validators map[auth.TokenType]TokenValidator
+		acceptAll  bool
+
+		mu     sync.RWMutex
+		claims []Claim
+
+		// for unit tests
+		now func() time.Time
+	}
+
+	// HandlerOption provides a way to customize the construction of a Handler
+	HandlerOption func(h *Handler) error
+
+	// TokenValidator checks a token of a type other than SAS which was put for audience. A returned ClaimError
+	// determines the status code of the response; any other error results in a 401.
+	TokenValidator func(ctx context.Context, token, audience string) error
+
+	// Claim is a put-token request received by a Handler
+	Claim struct {
+		// Audience is the audience the token was put for
+		Audience string
+		// TokenType is the type of the token
+		TokenType auth.TokenType
+		// Token is the token itself
+		Token string
+		// Expiry is the expiration sent with the token
+		Expiry time.Time
+		// Received is when the Handler received the request
+		Received time.Time
+		// Code is the status code the Handler responded with
+		Code int
+		// Description is the status description the Handler responded with
+		Description string
+	}
+
+	// MessageReceiver receives the requests sent to the $cbs node. *amqp.Receiver implements this interface.
+	MessageReceiver interface {
+		Receive(ctx context.Context, opts *amqp.ReceiveOptions) (*amqp.Message, error)
+		AcceptMessage(ctx context.Context, msg *amqp.Message) error
+	}
+
+	// MessageSender sends responses to the address in the reply-to of each request. *amqp.Sender implements this
+	// interface, as long as it is attached to an address where the broker routes messages by their To property.
+	MessageSender interface {
+		Send(ctx context.Context, msg *amqp.Message, opts *amqp.SendOptions) error
+	}
+)
+
+// HandlerWithKey configures a Handler to accept SAS tokens signed by the named key
+func HandlerWithKey(keyName, key string) HandlerOption {
+	return func(h *Handler) error {
+		if keyName == "" || key == "" {
+			return errors.New("key name and key must not be empty")
+		}
+		h.keys[keyName] = key
+		return nil
+	}
+}
+
+// HandlerWithTokenValidator configures a Handler to check tokens of the given type with validator
+func HandlerWithTokenValidator(tokenType auth.TokenType, validator TokenValidator) HandlerOption {
+	return func(h *Handler) error {
+		if validator == nil {
+			return errors.New("validator must not be nil")
+		}
+		h.validators[tokenType] = validator
+		return nil
+	}
+}
+
+// HandlerWithAcceptAll configures a Handler to accept every well formed put-token request without checking the token,
+// only recording it
+func HandlerWithAcceptAll() HandlerOption {
+	return func(h *Handler) error {
+		h.acceptAll = true
+		return nil
+	}
+}
+
+// NewHandler builds a Handler for $cbs put-token requests
+func NewHandler(opts ...HandlerOption) (*Handler, error) {
+	h := &Handler{
+		keys:       map[string]string{},
+		validators: map[auth.TokenType]TokenValidator{},
+		now:        time.Now,
+	}
+
+	for _, opt := range opts {
+		if err := opt(h); err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+// Serve receives put-token requests from receiver and sends a response for each through sender until ctx is done or
+// either link fails
+func (h *Handler) Serve(ctx context.Context, receiver MessageReceiver, sender MessageSender) error {
+	for {
+		req, err := receiver.Receive(ctx, nil)
+		if err != nil {
+			return err
+		}
+
+		if err := receiver.AcceptMessage(ctx, req); err != nil {
+			return err
+		}
+
+		if err := sender.Send(ctx, h.HandleRequest(ctx, req), nil); err != nil {
+			return err
+		}
+	}
+}
+
+// HandleRequest checks a put-token request and builds the response to it. The response is addressed to the reply-to
+// of the request and correlated with its message ID.
+func (h *Handler) HandleRequest(ctx context.Context, req *amqp.Message) *amqp.Message {
+	claim := h.checkRequest(ctx, req)
+
+	h.mu.Lock()
+	h.claims = append(h.claims, claim)
+	h.mu.Unlock()
+
+	res := &amqp.Message{
+		Properties: &amqp.MessageProperties{},
+		ApplicationProperties: map[string]interface{}{
+			statusCodeKey:        int32(claim.Code),
+			statusDescriptionKey: claim.Description,
+		},
+	}
+
+	if req.Properties != nil {
+		res.Properties.CorrelationID = req.Properties.MessageID
+		res.Properties.To = req.Properties.ReplyTo
+	}
+	return res
+}
+
+// Claims returns every put-token request the Handler has received, in the order they were received
+func (h *Handler) Claims() []Claim {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	claims := make([]Claim, len(h.claims))
+	copy(claims, h.claims)
+	return claims
+}
+
+// Authorized returns true if an accepted token which has not expired grants access to audience. A token grants
+// access to its own audience and to every audience below it, so a token for a namespace authorizes all of its
+// entities.
+func (h *Handler) Authorized(audience string) bool {
+	now := h.now()
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for _, claim := range h.claims {
+		if claim.Code == http.StatusAccepted && claim.Expiry.After(now) && audienceCovers(claim.Audience, audience) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Handler) checkRequest(ctx context.Context, req *amqp.Message) Claim {
+	claim := Claim{
+		Received: h.now(),
+	}
+
+	reject := func(code int, format string, args ...interface{}) Claim {
+		claim.Code = code
+		claim.Description = fmt.Sprintf(format, args...)
+		return claim
+	}
+
+	if operation, _ := req.ApplicationProperties[cbsOperationKey].(string); operation != cbsOperationPutToken {
+		return reject(http.StatusBadRequest, "unsupported operation %q", operation)
+	}
+
+	tokenType, _ := req.ApplicationProperties[cbsTokenTypeKey].(string)
+	claim.TokenType = auth.TokenType(tokenType)
+	if tokenType == "" {
+		return reject(http.StatusBadRequest, "the %q application property is required", cbsTokenTypeKey)
+	}
+
+	claim.Audience, _ = req.ApplicationProperties[cbsAudienceKey].(string)
+	if claim.Audience == "" {
+		return reject(http.StatusBadRequest, "the %q application property is required", cbsAudienceKey)
+	}
+
+	expiry, err := requestExpiry(req.ApplicationProperties[cbsExpirationKey])
+	if err != nil {
+		return reject(http.StatusBadRequest, "the %q application property %s", cbsExpirationKey, err)
+	}
+	claim.Expiry = expiry
+
+	claim.Token, _ = req.Value.(string)
+	if claim.Token == "" {
+		return reject(http.StatusBadRequest, "the message body must contain the token")
+	}
+
+	if err := h.checkToken(ctx, claim); err != nil {
+		var claimErr *ClaimError
+		if errors.As(err, &claimErr) {
+			return reject(claimErr.Code, "%s", claimErr.Description)
+		}
+		return reject(http.StatusUnauthorized, "%s", err.Error())
+	}
+
+	claim.Code = http.StatusAccepted
+	claim.Description = "Accepted"
+	return claim
+}
+
+func (h *Handler) checkToken(ctx context.Context, claim Claim) error {
+	if h.acceptAll {
+		return nil
+	}
+
+	if validator, ok := h.validators[claim.TokenType]; ok {
+		return validator(ctx, claim.Token, claim.Audience)
+	}
+
+	if claim.TokenType == auth.CBSTokenTypeSAS {
+		return h.checkSAS(claim.Token, claim.Audience)
+	}
+
+	return &ClaimError{
+		Code:        http.StatusBadRequest,
+		Description: fmt.Sprintf("unsupported token type %q", claim.TokenType),
+	}
+}
+
+// checkSAS verifies the signature, expiry and scope of a SAS token
+func (h *Handler) checkSAS(token, audience string) error {
+	fields, err := parseSAS(token)
+	if err != nil {
+		return err
+	}
+
+	key, ok := h.keys[fields["skn"]]
+	if !ok {
+		return fmt.Errorf("unknown key name %q", fields["skn"])
+	}
+
+	resource, err := url.QueryUnescape(fields["sr"])
+	if err != nil {
+		return fmt.Errorf("malformed resource %q", fields["sr"])
+	}
+
+	expected, err := parseSAS(sas.NewSigner(fields["skn"], key).SignWithExpiry(resource, fields["se"]))
+	if err != nil {
+		return err
+	}
+
+	if !hmac.Equal([]byte(expected["sig"]), []byte(fields["sig"])) {
+		return errors.New("invalid signature")
+	}
+
+	expiry, err := parseExpiry(fields["se"])
+	if err != nil {
+		return fmt.Errorf("malformed expiry %q", fields["se"])
+	}
+
+	if !expiry.After(h.now()) {
+		return errors.New("token has expired")
+	}
+
+	if !audienceCovers(resource, audience) {
+		return fmt.Errorf("token for %s does not grant access to %s", resource, audience)
+	}
+	return nil
+}
+
+// requestExpiry reads the expiration of a put-token request. Clients send it as a string or a number of seconds since
+// the Unix epoch, or as an AMQP timestamp.
+func requestExpiry(v interface{}) (time.Time, error) {
+	switch v := v.(type) {
+	case string:
+		expiry, err := parseExpiry(v)
+		if err != nil {
+			return time.Time{}, errors.New("must be a unix timestamp")
+		}
+		return expiry, nil
+	case int32:
+		return time.Unix(int64(v), 0), nil
+	case int64:
+		return time.Unix(v, 0), nil
+	case uint32:
+		return time.Unix(int64(v), 0), nil
+	case uint64:
+		if v > 1<<62 {
+			return time.Time{}, errors.New("is out of range")
+		}
+		return time.Unix(int64(v), 0), nil
+	case time.Time:
+		return v, nil
+	case nil:
+		return time.Time{}, errors.New("is required")
+	default:
+		return time.Time{}, fmt.Errorf("must be a unix timestamp or a timestamp, not a %T", v)
+	}
+}
+
+// parseSAS splits a SAS token into its sr, sig, se and skn fields
+func parseSAS(token string) (map[string]string, error) {
+	if !strings.HasPrefix(token, sasTokenPrefix) {
+		return nil, errors.New("malformed SAS token")
+	}
+
+	fields := map[string]string{}
+	for _, pair := range strings.Split(strings.TrimPrefix(token, sasTokenPrefix), "&") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, errors.New("malformed SAS token")
+		}
+		fields[kv[0]] = kv[1]
+	}
+
+	for _, key := range []string{"sr", "sig", "se", "skn"} {
+		if fields[key] == "" {
+			return nil, fmt.Errorf("SAS token is missing %q", key)
+		}
+	}
+	return fields, nil
+}
+
+// audienceCovers returns true if a claim for granted also grants access to audience, comparing the canonical forms of
+// both case insensitively
+func audienceCovers(granted, audience string) bool {
+	normalize := func(a string) string {
+		if normalized, err := conn.NormalizeAudience(a); err == nil {
+			a = normalized
+		}
+		return strings.ToLower(strings.TrimSuffix(a, "/"))
+	}
+
+	granted, audience = normalize(granted), normalize(audience)
+	return audience == granted || strings.HasPrefix(audience, granted+"/")
+}
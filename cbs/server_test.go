@@ -0,0 +1,229 @@
+package cbs
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/Azure/azure-amqp-common-go/v4/auth"
+	"github.com/Azure/azure-amqp-common-go/v4/sas"
+	"github.com/Azure/go-amqp"
+)
+
+const (
+	testKeyName  = "RootManageSharedAccessKey"
+	testKey      = "superSecret="
+	testAudience = "amqps://mynamespace.servicebus.windows.net/myqueue"
+)
+
+func TestHandlerAcceptsValidSAS(t *testing.T) {
+	h := newTestHandler(t, HandlerWithKey(testKeyName, testKey))
+	provider, err := sas.NewTokenProvider(sas.TokenProviderWithKey(testKeyName, testKey))
+	require.NoError(t, err)
+
+	token, err := provider.GetToken(testAudience)
+	require.NoError(t, err)
+
+	replyTo := "cbs-reply-to"
+	req := newPutTokenRequest(token, testAudience)
+	req.Properties = &amqp.MessageProperties{MessageID: "message-id", ReplyTo: &replyTo}
+
+	res := h.HandleRequest(context.Background(), req)
+	require.EqualValues(t, http.StatusAccepted, res.ApplicationProperties[statusCodeKey])
+	require.Equal(t, "message-id", res.Properties.CorrelationID)
+	require.Equal(t, &replyTo, res.Properties.To)
+
+	claims := h.Claims()
+	require.Len(t, claims, 1)
+	require.Equal(t, testAudience, claims[0].Audience)
+	require.Equal(t, auth.CBSTokenTypeSAS, claims[0].TokenType)
+
+	require.True(t, h.Authorized(testAudience))
+	require.True(t, h.Authorized("sb://MyNamespace.servicebus.windows.net/myqueue/"))
+	require.False(t, h.Authorized("amqps://mynamespace.servicebus.windows.net/otherqueue"))
+}
+
+func TestHandlerNamespaceTokenCoversEntities(t *testing.T) {
+	h := newTestHandler(t, HandlerWithKey(testKeyName, testKey))
+	signer := sas.NewSigner(testKeyName, testKey)
+	signature, expiry := signer.SignWithDuration("sb://mynamespace.servicebus.windows.net/", time.Hour)
+
+	res := h.HandleRequest(context.Background(), newPutTokenRequest(auth.NewToken(auth.CBSTokenTypeSAS, signature, expiry), testAudience))
+	require.EqualValues(t, http.StatusAccepted, res.ApplicationProperties[statusCodeKey], res.ApplicationProperties[statusDescriptionKey])
+	require.True(t, h.Authorized(testAudience))
+}
+
+func TestHandlerRejectsInvalidSAS(t *testing.T) {
+	h := newTestHandler(t, HandlerWithKey(testKeyName, testKey))
+	now := time.Now()
+
+	tests := map[string]*auth.Token{
+		"wrong key":        tokenFor(sas.NewSigner(testKeyName, "wrong"), testAudience, now.Add(time.Hour)),
+		"unknown key name": tokenFor(sas.NewSigner("other", testKey), testAudience, now.Add(time.Hour)),
+		"expired":          tokenFor(sas.NewSigner(testKeyName, testKey), testAudience, now.Add(-time.Minute)),
+		"other entity":     tokenFor(sas.NewSigner(testKeyName, testKey), "amqps://mynamespace.servicebus.windows.net/other", now.Add(time.Hour)),
+		"not a SAS token":  auth.NewToken(auth.CBSTokenTypeSAS, "garbage", strconv.FormatInt(now.Add(time.Hour).Unix(), 10)),
+	}
+
+	for name, token := range tests {
+		t.Run(name, func(t *testing.T) {
+			res := h.HandleRequest(context.Background(), newPutTokenRequest(token, testAudience))
+			require.EqualValues(t, http.StatusUnauthorized, res.ApplicationProperties[statusCodeKey])
+			require.NotEmpty(t, res.ApplicationProperties[statusDescriptionKey])
+		})
+	}
+	require.False(t, h.Authorized(testAudience))
+}
+
+func TestHandlerRejectsMalformedRequests(t *testing.T) {
+	h := newTestHandler(t, HandlerWithAcceptAll())
+	valid := func() *amqp.Message {
+		return newPutTokenRequest(auth.NewToken(auth.CBSTokenTypeJWT, "token", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)), testAudience)
+	}
+
+	tests := map[string]func(msg *amqp.Message){
+		"operation":        func(msg *amqp.Message) { msg.ApplicationProperties[cbsOperationKey] = "delete-token" },
+		"type":             func(msg *amqp.Message) { delete(msg.ApplicationProperties, cbsTokenTypeKey) },
+		"name":             func(msg *amqp.Message) { delete(msg.ApplicationProperties, cbsAudienceKey) },
+		"expiration":       func(msg *amqp.Message) { msg.ApplicationProperties[cbsExpirationKey] = "soon" },
+		"float expiration": func(msg *amqp.Message) { msg.ApplicationProperties[cbsExpirationKey] = 1.5 },
+		"bool expiration":  func(msg *amqp.Message) { msg.ApplicationProperties[cbsExpirationKey] = true },
+		"token":            func(msg *amqp.Message) { msg.Value = nil },
+	}
+
+	for name, mutate := range tests {
+		t.Run(name, func(t *testing.T) {
+			msg := valid()
+			mutate(msg)
+			res := h.HandleRequest(context.Background(), msg)
+			require.EqualValues(t, http.StatusBadRequest, res.ApplicationProperties[statusCodeKey])
+		})
+	}
+
+	res := h.HandleRequest(context.Background(), valid())
+	require.EqualValues(t, http.StatusAccepted, res.ApplicationProperties[statusCodeKey])
+}
+
+func TestHandlerExpirationForms(t *testing.T) {
+	expiry := time.Now().Add(time.Hour).Truncate(time.Second)
+	forms := map[string]interface{}{
+		"string":    strconv.FormatInt(expiry.Unix(), 10),
+		"int64":     expiry.Unix(),
+		"int32":     int32(expiry.Unix()),
+		"uint32":    uint32(expiry.Unix()),
+		"uint64":    uint64(expiry.Unix()),
+		"timestamp": expiry,
+	}
+
+	for name, form := range forms {
+		t.Run(name, func(t *testing.T) {
+			h := newTestHandler(t, HandlerWithAcceptAll())
+			msg := newPutTokenRequest(auth.NewToken(auth.CBSTokenTypeJWT, "token", ""), testAudience)
+			msg.ApplicationProperties[cbsExpirationKey] = form
+
+			res := h.HandleRequest(context.Background(), msg)
+			require.EqualValues(t, http.StatusAccepted, res.ApplicationProperties[statusCodeKey], res.ApplicationProperties[statusDescriptionKey])
+			claims := h.Claims()
+			require.Len(t, claims, 1)
+			require.True(t, expiry.Equal(claims[0].Expiry))
+		})
+	}
+
+	h := newTestHandler(t, HandlerWithAcceptAll())
+	msg := newPutTokenRequest(auth.NewToken(auth.CBSTokenTypeJWT, "token", ""), testAudience)
+	msg.ApplicationProperties[cbsExpirationKey] = 1.5
+	res := h.HandleRequest(context.Background(), msg)
+	require.EqualValues(t, http.StatusBadRequest, res.ApplicationProperties[statusCodeKey])
+	require.Equal(t, `the "expiration" application property must be a unix timestamp or a timestamp, not a float64`, res.ApplicationProperties[statusDescriptionKey])
+}
+
+func TestHandlerTokenValidator(t *testing.T) {
+	h := newTestHandler(t, HandlerWithTokenValidator(auth.CBSTokenTypeJWT, func(ctx context.Context, token, audience string) error {
+		switch token {
+		case "good":
+			return nil
+		case "missing":
+			return &ClaimError{Code: http.StatusNotFound, Description: "no such entity"}
+		default:
+			return errors.New("bad token")
+		}
+	}))
+	expiry := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+
+	codes := map[string]int32{"good": http.StatusAccepted, "missing": http.StatusNotFound, "bad": http.StatusUnauthorized}
+	for token, code := range codes {
+		res := h.HandleRequest(context.Background(), newPutTokenRequest(auth.NewToken(auth.CBSTokenTypeJWT, token, expiry), testAudience))
+		require.Equal(t, code, res.ApplicationProperties[statusCodeKey], token)
+	}
+
+	// SAS tokens are rejected since the handler has no keys
+	res := h.HandleRequest(context.Background(), newPutTokenRequest(tokenFor(sas.NewSigner(testKeyName, testKey), testAudience, time.Now().Add(time.Hour)), testAudience))
+	require.EqualValues(t, http.StatusUnauthorized, res.ApplicationProperties[statusCodeKey])
+}
+
+func TestHandlerServe(t *testing.T) {
+	h := newTestHandler(t, HandlerWithAcceptAll())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	receiver := &fakeMessageReceiver{
+		requests: []*amqp.Message{
+			newPutTokenRequest(auth.NewToken(auth.CBSTokenTypeJWT, "token", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)), testAudience),
+		},
+		cancel: cancel,
+	}
+	sender := &fakeMessageSender{}
+
+	err := h.Serve(ctx, receiver, sender)
+	require.ErrorIs(t, err, context.Canceled)
+	require.Len(t, sender.sent, 1)
+	require.Equal(t, 1, receiver.accepted)
+	require.EqualValues(t, http.StatusAccepted, sender.sent[0].ApplicationProperties[statusCodeKey])
+}
+
+func newTestHandler(t *testing.T, opts ...HandlerOption) *Handler {
+	h, err := NewHandler(opts...)
+	require.NoError(t, err)
+	return h
+}
+
+func tokenFor(signer *sas.Signer, audience string, expiry time.Time) *auth.Token {
+	se := strconv.FormatInt(expiry.Unix(), 10)
+	return auth.NewToken(auth.CBSTokenTypeSAS, signer.SignWithExpiry(audience, se), se)
+}
+
+type fakeMessageReceiver struct {
+	requests []*amqp.Message
+	accepted int
+	cancel   context.CancelFunc
+}
+
+func (r *fakeMessageReceiver) Receive(ctx context.Context, opts *amqp.ReceiveOptions) (*amqp.Message, error) {
+	if len(r.requests) == 0 {
+		r.cancel()
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	msg := r.requests[0]
+	r.requests = r.requests[1:]
+	return msg, nil
+}
+
+func (r *fakeMessageReceiver) AcceptMessage(ctx context.Context, msg *amqp.Message) error {
+	r.accepted++
+	return nil
+}
+
+type fakeMessageSender struct {
+	sent []*amqp.Message
+}
+
+func (s *fakeMessageSender) Send(ctx context.Context, msg *amqp.Message, opts *amqp.SendOptions) error {
+	s.sent = append(s.sent, msg)
+	return nil
+}
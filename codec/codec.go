@@ -0,0 +1,660 @@
+// Package codec converts between Go values and the maps and lists carried in the bodies of AMQP management requests
+// and responses.
+//
+// Struct fields are named by their amqp tag, such as `amqp:"lock-token"`, or after the field itself if they have none.
+// The omitempty option leaves out fields with a zero value, and fields tagged "-" are skipped. The fields of embedded
+// structs are promoted into the outer struct, as with encoding/json. The property option is reserved for rpc.Call,
+// which sends such fields as application properties; any other option is reported as an error by Marshal and Unmarshal.
+package codec
+
+//	MIT License
+//
+//	Copyright (c) Microsoft Corporation. All rights reserved.
+//
+//	Permission is hereby granted, free of charge, to any person obtaining a copy
+//	of this software and associated documentation files (the "Software"), to deal
+//	in the Software without restriction, including without limitation the rights
+//	to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//	copies of the Software, and to permit persons to whom the Software is
+//	furnished to do so, subject to the following conditions:
+//
+//	The above copyright notice and this permission notice shall be included in all
+//	copies or substantial portions of the Software.
+//
+//	THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//	AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//	LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//	OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//	SOFTWARE
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/Azure/go-amqp"
+)
+
+// knownOptions are the tag options other than omitempty which are accepted
+var knownOptions = map[string]bool{"property": true}
+
+var (
+	stringType = reflect.TypeOf("")
+	timeType   = reflect.TypeOf(time.Time{})
+	uuidType   = reflect.TypeOf(amqp.UUID{})
+)
+
+type (
+	// Field describes how a struct field is encoded
+	Field struct {
+		// Name is the key of the field in the encoded map
+		Name string
+		// Index is the index sequence of the field, as for reflect.Value.FieldByIndex. It has more than one element for
+		// fields promoted from embedded structs.
+		Index []int
+		// OmitEmpty is true if the field is left out when it has its zero value
+		OmitEmpty bool
+		// Options are the options of the amqp tag of the field, other than omitempty
+		Options []string
+	}
+
+	// DecodeError is returned when a value cannot be decoded into a Go type
+	DecodeError struct {
+		// Field is the path of the value which could not be decoded, such as "messages[0].lock-token". It is empty
+		// for the value itself.
+		Field string
+		// Value is the value which could not be decoded
+		Value interface{}
+		// Type is the Go type the value was decoded into
+		Type reflect.Type
+		// Err is the reason the value could not be decoded, if there is one beyond the types not matching
+		Err error
+	}
+)
+
+// Fields returns the encoded fields of a struct type, in the order they are declared. Unexported fields and fields
+// tagged "-" are skipped. The fields of embedded structs without a tag are promoted into the outer struct the way
+// encoding/json does it: a field of the outer struct hides a field of the same name further down, and fields of the
+// same name at the same depth hide each other, unless exactly one of them is tagged.
+func Fields(t reflect.Type) []Field {
+	var candidates []candidate
+	collectFields(t, nil, map[reflect.Type]bool{}, &candidates)
+
+	byName := map[string][]candidate{}
+	for _, c := range candidates {
+		byName[c.Name] = append(byName[c.Name], c)
+	}
+
+	fields := make([]Field, 0, len(candidates))
+	for _, c := range candidates {
+		if dominant, ok := dominantField(byName[c.Name]); ok && sameIndex(dominant.Index, c.Index) {
+			fields = append(fields, c.Field)
+		}
+	}
+	return fields
+}
+
+// candidate is a field which may be encoded, unless a field of the same name hides it
+type candidate struct {
+	Field
+	tagged bool
+}
+
+func collectFields(t reflect.Type, index []int, visiting map[reflect.Type]bool, candidates *[]candidate) {
+	if visiting[t] {
+		// an embedded struct which embeds itself contributes no further fields
+		return
+	}
+	visiting[t] = true
+	defer delete(visiting, t)
+
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("amqp")
+		if tag == "-" {
+			continue
+		}
+
+		parts := strings.Split(tag, ",")
+		fieldIndex := append(index[:len(index):len(index)], i)
+
+		if sf.Anonymous && parts[0] == "" {
+			ft := sf.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct && ft != timeType {
+				collectFields(ft, fieldIndex, visiting, candidates)
+				continue
+			}
+		}
+
+		if sf.PkgPath != "" {
+			continue
+		}
+
+		f := Field{Name: parts[0], Index: fieldIndex}
+		if f.Name == "" {
+			f.Name = sf.Name
+		}
+
+		for _, opt := range parts[1:] {
+			if opt == "omitempty" {
+				f.OmitEmpty = true
+			} else if opt != "" {
+				f.Options = append(f.Options, opt)
+			}
+		}
+		*candidates = append(*candidates, candidate{Field: f, tagged: parts[0] != ""})
+	}
+}
+
+// dominantField picks the field which is encoded among the fields sharing a name, if there is one
+func dominantField(fields []candidate) (candidate, bool) {
+	depth := len(fields[0].Index)
+	for _, f := range fields[1:] {
+		if len(f.Index) < depth {
+			depth = len(f.Index)
+		}
+	}
+
+	var shallowest []candidate
+	for _, f := range fields {
+		if len(f.Index) == depth {
+			shallowest = append(shallowest, f)
+		}
+	}
+	if len(shallowest) == 1 {
+		return shallowest[0], true
+	}
+
+	var tagged []candidate
+	for _, f := range shallowest {
+		if f.tagged {
+			tagged = append(tagged, f)
+		}
+	}
+	if len(tagged) == 1 {
+		return tagged[0], true
+	}
+	return candidate{}, false
+}
+
+func sameIndex(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// checkOptions returns an error if a field of t is tagged with an option codec does not know, most likely a typo
+func checkOptions(t reflect.Type, fields []Field) error {
+	for _, f := range fields {
+		for _, opt := range f.Options {
+			if !knownOptions[opt] {
+				return fmt.Errorf("codec: unknown option %q in the amqp tag of field %s of %s", opt, f.Name, t)
+			}
+		}
+	}
+	return nil
+}
+
+// HasOption returns true if the tag of the field has the given option
+func (f Field) HasOption(option string) bool {
+	for _, opt := range f.Options {
+		if opt == option {
+			return true
+		}
+	}
+	return false
+}
+
+// Marshal converts v into a value which can be sent in an AMQP message. Structs and maps with string keys become
+// map[string]interface{}, while maps with other keys, including named string types such as symbols, become
+// map[interface{}]interface{} with their keys unchanged. Slices and arrays other than bytes become []interface{}, 16
+// byte arrays such as UUIDs become amqp.UUID, and nil pointers become nil.
+func Marshal(v interface{}) (interface{}, error) {
+	return marshal(reflect.ValueOf(v))
+}
+
+func marshal(v reflect.Value) (interface{}, error) {
+	if !v.IsValid() {
+		return nil, nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil, nil
+		}
+		return marshal(v.Elem())
+	case reflect.Struct:
+		if v.Type() == timeType {
+			return v.Interface(), nil
+		}
+
+		fields := Fields(v.Type())
+		if err := checkOptions(v.Type(), fields); err != nil {
+			return nil, err
+		}
+
+		m := map[string]interface{}{}
+		for _, f := range fields {
+			fv, err := v.FieldByIndexErr(f.Index)
+			if err != nil {
+				// the field is promoted from a nil embedded pointer
+				continue
+			}
+			if f.OmitEmpty && fv.IsZero() {
+				continue
+			}
+
+			encoded, err := marshal(fv)
+			if err != nil {
+				return nil, fmt.Errorf("encoding field %s: %w", f.Name, err)
+			}
+			m[f.Name] = encoded
+		}
+		return m, nil
+	case reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			if v.Len() == len(amqp.UUID{}) {
+				return v.Convert(uuidType).Interface(), nil
+			}
+			return v.Interface(), nil
+		}
+		return marshalList(v)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return v.Bytes(), nil
+		}
+		if v.IsNil() {
+			return nil, nil
+		}
+		return marshalList(v)
+	case reflect.Map:
+		if v.IsNil() {
+			return nil, nil
+		}
+		if v.Type().Key() != stringType {
+			return marshalMap(v)
+		}
+
+		m := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			encoded, err := marshal(iter.Value())
+			if err != nil {
+				return nil, err
+			}
+			m[iter.Key().String()] = encoded
+		}
+		return m, nil
+	case reflect.Func, reflect.Chan, reflect.UnsafePointer, reflect.Complex64, reflect.Complex128:
+		return nil, fmt.Errorf("cannot encode a value of type %s", v.Type())
+	default:
+		return v.Interface(), nil
+	}
+}
+
+// marshalMap encodes a map whose keys are not plain strings into a map[interface{}]interface{}, keeping the type of
+// each key so that, for instance, symbol keys stay symbols
+func marshalMap(v reflect.Value) (interface{}, error) {
+	m := make(map[interface{}]interface{}, v.Len())
+	iter := v.MapRange()
+	for iter.Next() {
+		encoded, err := marshal(iter.Value())
+		if err != nil {
+			return nil, err
+		}
+		m[iter.Key().Interface()] = encoded
+	}
+	return m, nil
+}
+
+func marshalList(v reflect.Value) (interface{}, error) {
+	list := make([]interface{}, v.Len())
+	for i := range list {
+		encoded, err := marshal(v.Index(i))
+		if err != nil {
+			return nil, err
+		}
+		list[i] = encoded
+	}
+	return list, nil
+}
+
+// Unmarshal stores src, a value decoded from an AMQP message, into the value v points to. Maps are decoded into
+// structs by field name and lists into slices. Numbers are decoded into any numeric type which can hold them, symbols
+// into strings, timestamps into time.Time, and UUIDs into any 16 byte array or a string. Missing fields are left
+// untouched and nil values set pointers, slices and maps to nil.
+func Unmarshal(src interface{}, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("codec: Unmarshal requires a non-nil pointer")
+	}
+	return unmarshal(src, rv.Elem(), "")
+}
+
+func unmarshal(src interface{}, dst reflect.Value, path string) error {
+	if src == nil {
+		dst.Set(reflect.Zero(dst.Type()))
+		return nil
+	}
+
+	sv := reflect.ValueOf(src)
+	if sv.Type().AssignableTo(dst.Type()) {
+		dst.Set(sv)
+		return nil
+	}
+
+	switch dst.Kind() {
+	case reflect.Ptr:
+		elem := reflect.New(dst.Type().Elem())
+		if err := unmarshal(src, elem.Elem(), path); err != nil {
+			return err
+		}
+		dst.Set(elem)
+		return nil
+	case reflect.Interface:
+		if dst.NumMethod() == 0 {
+			dst.Set(sv)
+			return nil
+		}
+		return newDecodeError(path, src, dst.Type(), nil)
+	case reflect.Struct:
+		if dst.Type() == timeType {
+			return unmarshalTime(sv, dst, path)
+		}
+
+		if sv.Kind() != reflect.Map {
+			return newDecodeError(path, src, dst.Type(), nil)
+		}
+
+		fields := Fields(dst.Type())
+		if err := checkOptions(dst.Type(), fields); err != nil {
+			return err
+		}
+
+		for _, f := range fields {
+			value, ok := lookup(sv, f.Name)
+			if !ok {
+				continue
+			}
+			fv, ok := fieldByIndex(dst, f.Index)
+			if !ok {
+				continue
+			}
+			if err := unmarshal(value, fv, join(path, f.Name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Slice:
+		if sv.Kind() != reflect.Slice && sv.Kind() != reflect.Array {
+			return newDecodeError(path, src, dst.Type(), nil)
+		}
+
+		slice := reflect.MakeSlice(dst.Type(), sv.Len(), sv.Len())
+		for i := 0; i < sv.Len(); i++ {
+			if err := unmarshal(sv.Index(i).Interface(), slice.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+		dst.Set(slice)
+		return nil
+	case reflect.Array:
+		return unmarshalArray(sv, dst, path)
+	case reflect.Map:
+		if sv.Kind() != reflect.Map {
+			return newDecodeError(path, src, dst.Type(), nil)
+		}
+
+		m := reflect.MakeMapWithSize(dst.Type(), sv.Len())
+		iter := sv.MapRange()
+		for iter.Next() {
+			key := reflect.New(dst.Type().Key()).Elem()
+			if err := unmarshal(iter.Key().Interface(), key, path); err != nil {
+				return err
+			}
+
+			value := reflect.New(dst.Type().Elem()).Elem()
+			if err := unmarshal(iter.Value().Interface(), value, join(path, fmt.Sprint(iter.Key().Interface()))); err != nil {
+				return err
+			}
+			m.SetMapIndex(key, value)
+		}
+		dst.Set(m)
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := toInt64(sv)
+		if err == nil && dst.OverflowInt(n) {
+			err = errOutOfRange
+		}
+		if err != nil {
+			return newDecodeError(path, src, dst.Type(), nilIfMismatch(err))
+		}
+		dst.SetInt(n)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := toUint64(sv)
+		if err == nil && dst.OverflowUint(n) {
+			err = errOutOfRange
+		}
+		if err != nil {
+			return newDecodeError(path, src, dst.Type(), nilIfMismatch(err))
+		}
+		dst.SetUint(n)
+		return nil
+	case reflect.Float32, reflect.Float64:
+		var f float64
+		switch sv.Kind() {
+		case reflect.Float32, reflect.Float64:
+			f = sv.Float()
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			f = float64(sv.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			f = float64(sv.Uint())
+		default:
+			return newDecodeError(path, src, dst.Type(), nil)
+		}
+		if dst.OverflowFloat(f) {
+			return newDecodeError(path, src, dst.Type(), errOutOfRange)
+		}
+		dst.SetFloat(f)
+		return nil
+	case reflect.String:
+		switch {
+		case sv.Kind() == reflect.String:
+			// symbols decode to a named string type
+			dst.SetString(sv.String())
+		case sv.Type() == uuidType:
+			dst.SetString(src.(amqp.UUID).String())
+		default:
+			return newDecodeError(path, src, dst.Type(), nil)
+		}
+		return nil
+	case reflect.Bool:
+		if sv.Kind() != reflect.Bool {
+			return newDecodeError(path, src, dst.Type(), nil)
+		}
+		dst.SetBool(sv.Bool())
+		return nil
+	default:
+		return newDecodeError(path, src, dst.Type(), nil)
+	}
+}
+
+// fieldByIndex returns the field of v with the given index sequence, allocating the embedded structs it is promoted
+// from if they are nil pointers. It returns false if such a pointer cannot be set, because its type is unexported.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return reflect.Value{}, false
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// unmarshalTime decodes a timestamp, or a number of milliseconds since the Unix epoch, into a time.Time
+func unmarshalTime(sv reflect.Value, dst reflect.Value, path string) error {
+	if sv.Type() == timeType {
+		dst.Set(sv)
+		return nil
+	}
+
+	ms, err := toInt64(sv)
+	if err != nil {
+		return newDecodeError(path, sv.Interface(), dst.Type(), nilIfMismatch(err))
+	}
+	dst.Set(reflect.ValueOf(time.UnixMilli(ms)))
+	return nil
+}
+
+// unmarshalArray decodes a UUID, a UUID formatted as a string, or a list into an array
+func unmarshalArray(sv reflect.Value, dst reflect.Value, path string) error {
+	isBytes := dst.Type().Elem().Kind() == reflect.Uint8
+
+	switch {
+	case isBytes && sv.Kind() == reflect.Array && sv.Type().Elem().Kind() == reflect.Uint8 && sv.Len() == dst.Len():
+		reflect.Copy(dst, sv)
+		return nil
+	case isBytes && sv.Kind() == reflect.Slice && sv.Type().Elem().Kind() == reflect.Uint8 && sv.Len() == dst.Len():
+		reflect.Copy(dst, sv)
+		return nil
+	case isBytes && sv.Kind() == reflect.String && dst.Len() == len(amqp.UUID{}):
+		raw, err := hex.DecodeString(strings.ReplaceAll(sv.String(), "-", ""))
+		if err != nil || len(raw) != dst.Len() {
+			return newDecodeError(path, sv.Interface(), dst.Type(), errors.New("malformed UUID"))
+		}
+		reflect.Copy(dst, reflect.ValueOf(raw))
+		return nil
+	case sv.Kind() == reflect.Slice || sv.Kind() == reflect.Array:
+		if sv.Len() != dst.Len() {
+			return newDecodeError(path, sv.Interface(), dst.Type(), fmt.Errorf("expected %d elements, got %d", dst.Len(), sv.Len()))
+		}
+		for i := 0; i < sv.Len(); i++ {
+			if err := unmarshal(sv.Index(i).Interface(), dst.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return newDecodeError(path, sv.Interface(), dst.Type(), nil)
+	}
+}
+
+var (
+	errOutOfRange = errors.New("value out of range")
+	errMismatch   = errors.New("type mismatch")
+)
+
+func toInt64(sv reflect.Value) (int64, error) {
+	switch sv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return sv.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if sv.Uint() > 1<<63-1 {
+			return 0, errOutOfRange
+		}
+		return int64(sv.Uint()), nil
+	default:
+		return 0, errMismatch
+	}
+}
+
+func toUint64(sv reflect.Value) (uint64, error) {
+	switch sv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if sv.Int() < 0 {
+			return 0, errOutOfRange
+		}
+		return uint64(sv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return sv.Uint(), nil
+	default:
+		return 0, errMismatch
+	}
+}
+
+// nilIfMismatch drops errMismatch, which DecodeError already conveys through its types
+func nilIfMismatch(err error) error {
+	if err == errMismatch {
+		return nil
+	}
+	return err
+}
+
+// lookup finds key in a map decoded from AMQP, whose keys may be strings or symbols
+func lookup(m reflect.Value, key string) (interface{}, bool) {
+	if m.Type().Key().Kind() == reflect.String {
+		value := m.MapIndex(reflect.ValueOf(key).Convert(m.Type().Key()))
+		if value.IsValid() {
+			return value.Interface(), true
+		}
+		return nil, false
+	}
+
+	iter := m.MapRange()
+	for iter.Next() {
+		k := iter.Key()
+		if k.Kind() == reflect.Interface {
+			k = k.Elem()
+		}
+		if k.Kind() == reflect.String && k.String() == key {
+			return iter.Value().Interface(), true
+		}
+	}
+	return nil, false
+}
+
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func newDecodeError(field string, value interface{}, t reflect.Type, err error) *DecodeError {
+	return &DecodeError{
+		Field: field,
+		Value: value,
+		Type:  t,
+		Err:   err,
+	}
+}
+
+// Error implementation for DecodeError
+func (e *DecodeError) Error() string {
+	msg := fmt.Sprintf("cannot decode %T into %s", e.Value, e.Type)
+	if e.Field != "" {
+		msg = fmt.Sprintf("cannot decode %T into %s at %s", e.Value, e.Type, e.Field)
+	}
+
+	if e.Err != nil {
+		return msg + ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// Unwrap returns the underlying reason, if there is one
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,398 @@
+package codec
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-amqp"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Azure/azure-amqp-common-go/v4/uuid"
+)
+
+// symbol stands in for the type go-amqp decodes AMQP symbols into
+type symbol string
+
+type (
+	rule struct {
+		Name   string `amqp:"rule-name"`
+		Filter string `amqp:"filter,omitempty"`
+	}
+
+	entity struct {
+		ID         uuid.UUID         `amqp:"id"`
+		LockToken  string            `amqp:"lock-token"`
+		Sequence   int64             `amqp:"sequence-number"`
+		Count      uint16            `amqp:"count"`
+		Expires    time.Time         `amqp:"expires"`
+		SessionID  *string           `amqp:"session-id,omitempty"`
+		Rules      []rule            `amqp:"rules"`
+		Properties map[string]string `amqp:"properties,omitempty"`
+		Transient  string            `amqp:"-"`
+		Untagged   bool
+		unexported int
+	}
+)
+
+func TestMarshal(t *testing.T) {
+	id, err := uuid.NewV4()
+	require.NoError(t, err)
+	expires := time.Unix(1700000000, 0)
+
+	encoded, err := Marshal(&entity{
+		ID:        id,
+		LockToken: "token",
+		Sequence:  42,
+		Count:     3,
+		Expires:   expires,
+		Rules:     []rule{{Name: "all"}},
+		Transient: "not sent",
+		Untagged:  true,
+	})
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"id":              amqp.UUID(id),
+		"lock-token":      "token",
+		"sequence-number": int64(42),
+		"count":           uint16(3),
+		"expires":         expires,
+		"rules":           []interface{}{map[string]interface{}{"rule-name": "all"}},
+		"Untagged":        true,
+	}, encoded)
+}
+
+func TestMarshalNil(t *testing.T) {
+	encoded, err := Marshal((*entity)(nil))
+	require.NoError(t, err)
+	require.Nil(t, encoded)
+
+	_, err = Marshal(map[string]interface{}{"f": func() {}})
+	require.Error(t, err)
+}
+
+func TestUnmarshal(t *testing.T) {
+	id, err := uuid.NewV4()
+	require.NoError(t, err)
+	expires := time.Unix(1700000000, 0)
+
+	var e entity
+	require.NoError(t, Unmarshal(map[interface{}]interface{}{
+		symbol("id"):              amqp.UUID(id),
+		symbol("lock-token"):      symbol("token"),
+		"sequence-number":         int32(42),
+		"count":                   int64(3),
+		"expires":                 expires,
+		"session-id":              "session",
+		"rules":                   []interface{}{map[string]interface{}{"rule-name": "all", "filter": "1=1"}},
+		"properties":              map[string]interface{}{"a": symbol("b")},
+		"Untagged":                true,
+		"not-a-field-of-the-type": "ignored",
+	}, &e))
+
+	session := "session"
+	require.Equal(t, entity{
+		ID:         id,
+		LockToken:  "token",
+		Sequence:   42,
+		Count:      3,
+		Expires:    expires,
+		SessionID:  &session,
+		Rules:      []rule{{Name: "all", Filter: "1=1"}},
+		Properties: map[string]string{"a": "b"},
+		Untagged:   true,
+	}, e)
+}
+
+func TestUnmarshalConversions(t *testing.T) {
+	id, err := uuid.NewV4()
+	require.NoError(t, err)
+
+	var fromString uuid.UUID
+	require.NoError(t, Unmarshal(id.String(), &fromString))
+	require.Equal(t, id, fromString)
+
+	var asString string
+	require.NoError(t, Unmarshal(amqp.UUID(id), &asString))
+	require.Equal(t, id.String(), asString)
+
+	var fromMillis time.Time
+	require.NoError(t, Unmarshal(int64(1700000000123), &fromMillis))
+	require.True(t, time.UnixMilli(1700000000123).Equal(fromMillis))
+
+	var widened float64
+	require.NoError(t, Unmarshal(uint32(7), &widened))
+	require.Equal(t, 7.0, widened)
+
+	var anything interface{}
+	require.NoError(t, Unmarshal(symbol("value"), &anything))
+	require.Equal(t, symbol("value"), anything)
+
+	optional := new(int)
+	require.NoError(t, Unmarshal(nil, &optional))
+	require.Nil(t, optional)
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	var e entity
+	err := Unmarshal(map[string]interface{}{
+		"rules": []interface{}{map[string]interface{}{"rule-name": int32(1)}},
+	}, &e)
+
+	var decodeErr *DecodeError
+	require.ErrorAs(t, err, &decodeErr)
+	require.Equal(t, "rules[0].rule-name", decodeErr.Field)
+	require.Equal(t, reflect.TypeOf(""), decodeErr.Type)
+	require.Nil(t, decodeErr.Err)
+
+	var small int8
+	err = Unmarshal(int64(300), &small)
+	require.ErrorAs(t, err, &decodeErr)
+	require.True(t, errors.Is(err, errOutOfRange))
+
+	var unsigned uint32
+	require.Error(t, Unmarshal(int32(-1), &unsigned))
+
+	var id uuid.UUID
+	require.Error(t, Unmarshal("not-a-uuid", &id))
+
+	require.Error(t, Unmarshal("value", e))
+}
+
+func TestFields(t *testing.T) {
+	type request struct {
+		Timeout int    `amqp:"server-timeout,property,omitempty"`
+		Name    string `amqp:"name"`
+	}
+
+	fields := Fields(reflect.TypeOf(request{}))
+	require.Len(t, fields, 2)
+	require.Equal(t, "server-timeout", fields[0].Name)
+	require.True(t, fields[0].OmitEmpty)
+	require.True(t, fields[0].HasOption("property"))
+	require.False(t, fields[1].HasOption("property"))
+}
+
+func TestMarshalKeepsKeyTypes(t *testing.T) {
+	type filter struct {
+		Properties map[symbol]string `amqp:"properties"`
+	}
+
+	encoded, err := Marshal(filter{Properties: map[symbol]string{"com.microsoft:session-filter": "a"}})
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"properties": map[interface{}]interface{}{symbol("com.microsoft:session-filter"): "a"},
+	}, encoded)
+
+	encoded, err = Marshal(map[int32]rule{1: {Name: "all"}})
+	require.NoError(t, err)
+	require.Equal(t, map[interface{}]interface{}{int32(1): map[string]interface{}{"rule-name": "all"}}, encoded)
+}
+
+type (
+	base struct {
+		ID       string `amqp:"id"`
+		Name     string `amqp:"name"`
+		Shadowed string `amqp:"shadowed"`
+	}
+
+	Audit struct {
+		Name    string `amqp:"name"`
+		Created int64  `amqp:"created"`
+	}
+
+	described struct {
+		base
+		*Audit
+		Shadowed string `amqp:"shadowed"`
+		Renamed  base   `amqp:"renamed,omitempty"`
+	}
+)
+
+func TestEmbeddedStructs(t *testing.T) {
+	fields := Fields(reflect.TypeOf(described{}))
+	names := make([]string, len(fields))
+	for i, f := range fields {
+		names[i] = f.Name
+	}
+	// name is declared by both embedded structs at the same depth, so neither is encoded
+	require.Equal(t, []string{"id", "created", "shadowed", "renamed"}, names)
+	require.Equal(t, []int{1, 1}, fields[1].Index)
+
+	encoded, err := Marshal(described{base: base{ID: "1", Name: "n", Shadowed: "inner"}, Shadowed: "outer"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"id": "1", "shadowed": "outer"}, encoded, "fields of a nil embedded pointer are left out")
+
+	var d described
+	require.NoError(t, Unmarshal(map[string]interface{}{"id": "2", "created": int64(7), "shadowed": "s"}, &d))
+	require.Equal(t, "2", d.ID)
+	require.NotNil(t, d.Audit)
+	require.EqualValues(t, 7, d.Created)
+	require.Equal(t, "s", d.Shadowed)
+	require.Empty(t, d.base.Shadowed)
+}
+
+func TestUnmarshalFloat32Overflow(t *testing.T) {
+	var f float32
+	err := Unmarshal(1e300, &f)
+
+	var decodeErr *DecodeError
+	require.ErrorAs(t, err, &decodeErr)
+	require.True(t, errors.Is(err, errOutOfRange))
+
+	require.NoError(t, Unmarshal(1.5, &f))
+	require.Equal(t, float32(1.5), f)
+}
+
+func TestMarshalTypes(t *testing.T) {
+	id, err := uuid.NewV4()
+	require.NoError(t, err)
+	expires := time.Unix(1700000000, 0)
+	session := "session"
+
+	type optional struct {
+		SessionID *string `amqp:"session-id"`
+	}
+
+	tests := map[string]struct {
+		value interface{}
+		want  interface{}
+	}{
+		"int32":            {value: int32(7), want: int32(7)},
+		"uint":             {value: uint(7), want: uint(7)},
+		"symbol":           {value: symbol("sym"), want: symbol("sym")},
+		"timestamp":        {value: expires, want: expires},
+		"amqp uuid":        {value: amqp.UUID(id), want: amqp.UUID(id)},
+		"uuid":             {value: id, want: amqp.UUID(id)},
+		"set pointer":      {value: optional{SessionID: &session}, want: map[string]interface{}{"session-id": "session"}},
+		"nil pointer":      {value: optional{}, want: map[string]interface{}{"session-id": nil}},
+		"nested list":      {value: []interface{}{int32(1), []interface{}{"a", expires}}, want: []interface{}{int32(1), []interface{}{"a", expires}}},
+		"nested any map":   {value: map[interface{}]interface{}{symbol("k"): map[interface{}]interface{}{int32(1): id}}, want: map[interface{}]interface{}{symbol("k"): map[interface{}]interface{}{int32(1): amqp.UUID(id)}}},
+		"list in any map":  {value: map[interface{}]interface{}{"rules": []rule{{Name: "all"}}}, want: map[interface{}]interface{}{"rules": []interface{}{map[string]interface{}{"rule-name": "all"}}}},
+		"map in list":      {value: []map[string]int64{{"a": 1}}, want: []interface{}{map[string]interface{}{"a": int64(1)}}},
+		"pointer to value": {value: &session, want: "session"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			encoded, err := Marshal(tt.value)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, encoded)
+		})
+	}
+}
+
+func TestUnmarshalTypes(t *testing.T) {
+	id, err := uuid.NewV4()
+	require.NoError(t, err)
+	expires := time.Unix(1700000000, 0)
+	session := "session"
+
+	type optional struct {
+		SessionID *string `amqp:"session-id"`
+		Count     *int64  `amqp:"count"`
+	}
+
+	tests := map[string]struct {
+		src  interface{}
+		into interface{}
+		want interface{}
+	}{
+		"int32 to int64":          {src: int32(-7), into: new(int64), want: int64(-7)},
+		"uint to int":             {src: uint(7), into: new(int), want: 7},
+		"uint64 to int64":         {src: uint64(1<<63 - 1), into: new(int64), want: int64(1<<63 - 1)},
+		"int64 to uint16":         {src: int64(65535), into: new(uint16), want: uint16(65535)},
+		"int to float":            {src: int64(3), into: new(float64), want: 3.0},
+		"symbol to string":        {src: symbol("sym"), into: new(string), want: "sym"},
+		"timestamp":               {src: expires, into: new(time.Time), want: expires},
+		"milliseconds":            {src: int64(1700000000000), into: new(time.Time), want: time.UnixMilli(1700000000000)},
+		"amqp uuid to uuid":       {src: amqp.UUID(id), into: new(uuid.UUID), want: id},
+		"amqp uuid to amqp uuid":  {src: amqp.UUID(id), into: new(amqp.UUID), want: amqp.UUID(id)},
+		"amqp uuid to string":     {src: amqp.UUID(id), into: new(string), want: id.String()},
+		"string to uuid":          {src: id.String(), into: new(uuid.UUID), want: id},
+		"optional set":            {src: map[string]interface{}{"session-id": session, "count": int32(2)}, into: new(optional), want: optional{SessionID: &session, Count: int64Ptr(2)}},
+		"optional missing":        {src: map[string]interface{}{}, into: new(optional), want: optional{}},
+		"optional nil":            {src: map[string]interface{}{"session-id": nil}, into: new(optional), want: optional{}},
+		"nested list":             {src: []interface{}{[]interface{}{int32(1), uint8(2)}}, into: new([][]int64), want: [][]int64{{1, 2}}},
+		"list into any":           {src: []interface{}{symbol("a"), int32(1)}, into: new(interface{}), want: []interface{}{symbol("a"), int32(1)}},
+		"any map into struct":     {src: map[interface{}]interface{}{symbol("rule-name"): symbol("all")}, into: new(rule), want: rule{Name: "all"}},
+		"any map into string map": {src: map[interface{}]interface{}{symbol("a"): int32(1)}, into: new(map[string]int64), want: map[string]int64{"a": 1}},
+		"nested any maps":         {src: map[interface{}]interface{}{"rules": []interface{}{map[interface{}]interface{}{symbol("filter"): "1=1"}}}, into: new(map[string][]rule), want: map[string][]rule{"rules": {{Filter: "1=1"}}}},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, Unmarshal(tt.src, tt.into))
+			require.Equal(t, tt.want, reflect.ValueOf(tt.into).Elem().Interface())
+		})
+	}
+}
+
+func TestUnmarshalErrorPaths(t *testing.T) {
+	type nested struct {
+		Rules []rule `amqp:"rules"`
+	}
+
+	tests := map[string]struct {
+		src        interface{}
+		into       interface{}
+		field      string
+		outOfRange bool
+	}{
+		"string into int":         {src: "1", into: new(int64)},
+		"int into string":         {src: int32(1), into: new(string)},
+		"list into struct":        {src: []interface{}{}, into: new(rule)},
+		"map into list":           {src: map[string]interface{}{}, into: new([]rule)},
+		"bool into time":          {src: true, into: new(time.Time)},
+		"nested wrong type":       {src: map[string]interface{}{"rules": []interface{}{map[string]interface{}{"rule-name": int32(1)}}}, into: new(nested), field: "rules[0].rule-name"},
+		"int32 overflow":          {src: int64(1 << 40), into: new(int32), outOfRange: true},
+		"uint64 overflows int64":  {src: uint64(1 << 63), into: new(int64), outOfRange: true},
+		"negative into uint":      {src: int32(-1), into: new(uint), outOfRange: true},
+		"nested overflow":         {src: map[string]interface{}{"count": int64(-1)}, into: new(entity), field: "count", outOfRange: true},
+		"timestamp out of range":  {src: uint64(1 << 63), into: new(time.Time), outOfRange: true},
+		"short uuid":              {src: []byte{1, 2}, into: new(uuid.UUID)},
+		"malformed uuid string":   {src: "not-a-uuid", into: new(uuid.UUID)},
+		"list into short array":   {src: []interface{}{int32(1)}, into: new([2]int32)},
+		"value into non-any type": {src: int32(1), into: new(error)},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := Unmarshal(tt.src, tt.into)
+
+			var decodeErr *DecodeError
+			require.ErrorAs(t, err, &decodeErr)
+			require.Equal(t, tt.field, decodeErr.Field)
+			require.Equal(t, tt.outOfRange, errors.Is(err, errOutOfRange))
+		})
+	}
+}
+
+func TestUnknownTagOptions(t *testing.T) {
+	type typo struct {
+		Name string `amqp:"name,omitempy"`
+	}
+
+	_, err := Marshal(typo{Name: "a"})
+	require.EqualError(t, err, `codec: unknown option "omitempy" in the amqp tag of field name of codec.typo`)
+
+	_, err = Marshal(map[string]interface{}{"nested": []typo{{}}})
+	require.Error(t, err)
+
+	var decoded typo
+	err = Unmarshal(map[string]interface{}{"name": "a"}, &decoded)
+	require.EqualError(t, err, `codec: unknown option "omitempy" in the amqp tag of field name of codec.typo`)
+
+	// property is left for rpc.Call
+	type request struct {
+		Timeout uint32 `amqp:"server-timeout,property"`
+	}
+	encoded, err := Marshal(request{Timeout: 1})
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"server-timeout": uint32(1)}, encoded)
+}
+
+func int64Ptr(n int64) *int64 {
+	return &n
+}
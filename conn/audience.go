@@ -0,0 +1,131 @@
+package conn
+
+//	MIT License
+//
+//	Copyright (c) Microsoft Corporation. All rights reserved.
+//
+//	Permission is hereby granted, free of charge, to any person obtaining a copy
+//	of this software and associated documentation files (the "Software"), to deal
+//	in the Software without restriction, including without limitation the rights
+//	to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//	copies of the Software, and to permit persons to whom the Software is
+//	furnished to do so, subject to the following conditions:
+//
+//	The above copyright notice and this permission notice shall be included in all
+//	copies or substantial portions of the Software.
+//
+//	THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//	AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//	LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//	OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//	SOFTWARE
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const (
+	audienceScheme       = "amqps"
+	subscriptionsSegment = "Subscriptions"
+	consumerGroupSegment = "ConsumerGroups"
+	partitionsSegment    = "Partitions"
+)
+
+// NormalizeAudience converts a claims-based security audience to its canonical form: the amqps scheme, a lower case
+// host and a path without empty segments or a trailing slash. The sb, amqp, http and https schemes are accepted and
+// replaced with amqps. Entity names keep their case; sas.Signer lower cases the whole audience when it builds the sr
+// field, so audiences which only differ in the case of an entity name produce the same signature.
+func NormalizeAudience(audience string) (string, error) {
+	u, err := url.Parse(audience)
+	if err != nil {
+		return "", fmt.Errorf("failed parsing audience %q: %w", audience, err)
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "amqps", "amqp", "sb", "https", "http":
+	default:
+		return "", fmt.Errorf("audience %q must use one of the amqps, amqp, sb, https or http schemes", audience)
+	}
+
+	if u.Host == "" {
+		return "", fmt.Errorf("audience %q must contain a host", audience)
+	}
+
+	return buildAudience(u.Host, u.Path), nil
+}
+
+// NamespaceAudience returns the audience which grants access to every entity in the namespace
+func (p *ParsedConn) NamespaceAudience() string {
+	return buildAudience(p.host())
+}
+
+// EntityAudience returns the audience for a queue, topic or Event Hub. If entityPath is empty, the EntityPath of the
+// connection string is used.
+func (p *ParsedConn) EntityAudience(entityPath string) (string, error) {
+	if entityPath == "" {
+		entityPath = p.HubName
+	}
+	if strings.Trim(entityPath, "/") == "" {
+		return "", errors.New("entity path must not be empty")
+	}
+	return buildAudience(p.host(), entityPath), nil
+}
+
+// SubscriptionAudience returns the audience for a subscription of a Service Bus topic
+func (p *ParsedConn) SubscriptionAudience(topic, subscription string) (string, error) {
+	if topic == "" || subscription == "" {
+		return "", errors.New("topic and subscription must not be empty")
+	}
+	return buildAudience(p.host(), topic, subscriptionsSegment, subscription), nil
+}
+
+// ConsumerGroupAudience returns the audience for a consumer group of an Event Hub. If hub is empty, the EntityPath of
+// the connection string is used.
+func (p *ParsedConn) ConsumerGroupAudience(hub, consumerGroup string) (string, error) {
+	if hub == "" {
+		hub = p.HubName
+	}
+	if hub == "" || consumerGroup == "" {
+		return "", errors.New("hub and consumer group must not be empty")
+	}
+	return buildAudience(p.host(), hub, consumerGroupSegment, consumerGroup), nil
+}
+
+// PartitionAudience returns the audience for a partition of an Event Hub consumer group. If hub is empty, the
+// EntityPath of the connection string is used.
+func (p *ParsedConn) PartitionAudience(hub, consumerGroup, partitionID string) (string, error) {
+	if partitionID == "" {
+		return "", errors.New("partition ID must not be empty")
+	}
+
+	audience, err := p.ConsumerGroupAudience(hub, consumerGroup)
+	if err != nil {
+		return "", err
+	}
+	return audience + "/" + partitionsSegment + "/" + partitionID, nil
+}
+
+// host returns the fully qualified host of the namespace
+func (p *ParsedConn) host() string {
+	return p.Namespace + "." + p.Suffix
+}
+
+// buildAudience joins host and path segments into a canonical audience
+func buildAudience(host string, segments ...string) string {
+	var sb strings.Builder
+	sb.WriteString(audienceScheme + "://" + strings.ToLower(strings.TrimSuffix(host, ".")))
+
+	for _, segment := range segments {
+		for _, part := range strings.Split(segment, "/") {
+			if part != "" {
+				sb.WriteString("/" + part)
+			}
+		}
+	}
+	return sb.String()
+}
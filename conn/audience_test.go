@@ -0,0 +1,98 @@
+package conn
+
+//	MIT License
+//
+//	Copyright (c) Microsoft Corporation. All rights reserved.
+//
+//	Permission is hereby granted, free of charge, to any person obtaining a copy
+//	of this software and associated documentation files (the "Software"), to deal
+//	in the Software without restriction, including without limitation the rights
+//	to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//	copies of the Software, and to permit persons to whom the Software is
+//	furnished to do so, subject to the following conditions:
+//
+//	The above copyright notice and this permission notice shall be included in all
+//	copies or substantial portions of the Software.
+//
+//	THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//	AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//	LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//	OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//	SOFTWARE
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const nsAudience = "amqps://" + namespace + ".servicebus.windows.net"
+
+func TestParsedConnAudiences(t *testing.T) {
+	parsed, err := ParsedConnectionFromStr("Endpoint=sb://MyNamespace.ServiceBus.Windows.Net/;SharedAccessKeyName=" + keyName + ";SharedAccessKey=" + secret + ";EntityPath=" + hubName)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, nsAudience, parsed.NamespaceAudience())
+
+	audience, err := parsed.EntityAudience("")
+	assert.NoError(t, err)
+	assert.Equal(t, nsAudience+"/"+hubName, audience)
+
+	audience, err = parsed.EntityAudience("/myQueue/")
+	assert.NoError(t, err)
+	assert.Equal(t, nsAudience+"/myQueue", audience)
+
+	audience, err = parsed.SubscriptionAudience("topic", "sub")
+	assert.NoError(t, err)
+	assert.Equal(t, nsAudience+"/topic/Subscriptions/sub", audience)
+
+	audience, err = parsed.ConsumerGroupAudience("", "$Default")
+	assert.NoError(t, err)
+	assert.Equal(t, nsAudience+"/"+hubName+"/ConsumerGroups/$Default", audience)
+
+	audience, err = parsed.PartitionAudience("otherhub", "$Default", "3")
+	assert.NoError(t, err)
+	assert.Equal(t, nsAudience+"/otherhub/ConsumerGroups/$Default/Partitions/3", audience)
+}
+
+func TestParsedConnAudienceErrors(t *testing.T) {
+	parsed, err := ParsedConnectionFromStr(noEntityPath)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	_, err = parsed.EntityAudience("")
+	assert.Error(t, err)
+	_, err = parsed.SubscriptionAudience("topic", "")
+	assert.Error(t, err)
+	_, err = parsed.ConsumerGroupAudience("", "$Default")
+	assert.Error(t, err)
+	_, err = parsed.PartitionAudience(hubName, "$Default", "")
+	assert.Error(t, err)
+}
+
+func TestNormalizeAudience(t *testing.T) {
+	for _, audience := range []string{
+		"amqps://mynamespace.servicebus.windows.net/myhub",
+		"sb://MyNamespace.servicebus.windows.net/myhub/",
+		"AMQPS://mynamespace.SERVICEBUS.windows.net//myhub",
+		"https://mynamespace.servicebus.windows.net/myhub",
+	} {
+		normalized, err := NormalizeAudience(audience)
+		assert.NoError(t, err, audience)
+		assert.Equal(t, nsAudience+"/myhub", normalized, audience)
+	}
+
+	normalized, err := NormalizeAudience("sb://mynamespace.servicebus.windows.net/")
+	assert.NoError(t, err)
+	assert.Equal(t, nsAudience, normalized)
+
+	_, err = NormalizeAudience("ftp://mynamespace.servicebus.windows.net/myhub")
+	assert.Error(t, err)
+	_, err = NormalizeAudience("mynamespace.servicebus.windows.net/myhub")
+	assert.Error(t, err)
+}
@@ -0,0 +1,113 @@
+package rpc
+
+//	MIT License
+//
+//	Copyright (c) Microsoft Corporation. All rights reserved.
+//
+//	Permission is hereby granted, free of charge, to any person obtaining a copy
+//	of this software and associated documentation files (the "Software"), to deal
+//	in the Software without restriction, including without limitation the rights
+//	to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//	copies of the Software, and to permit persons to whom the Software is
+//	furnished to do so, subject to the following conditions:
+//
+//	The above copyright notice and this permission notice shall be included in all
+//	copies or substantial portions of the Software.
+//
+//	THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//	AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//	LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//	OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//	SOFTWARE
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/Azure/go-amqp"
+
+	"github.com/Azure/azure-amqp-common-go/v4/codec"
+	"github.com/Azure/azure-amqp-common-go/v4/internal/tracing"
+)
+
+const operationKey = "operation"
+
+// DecodeError is returned when the body of a response cannot be decoded into the type requested by the caller
+type DecodeError = codec.DecodeError
+
+// Call sends a request for operation over link and decodes the response into a Resp. The request is encoded with
+// package codec into a map which becomes the body of the message; fields tagged with the property option, such as
+// `amqp:"name,property"`, are sent as application properties instead. The operation is set as the operation
+// application property.
+//
+// A response with a status code outside of the 2xx range results in a *StatusError, and a body which cannot be decoded
+// into a Resp in a *DecodeError. Call makes a single attempt; use the retry policy of the caller to retry it.
+func Call[Req, Resp any](ctx context.Context, link *Link, operation string, req Req) (Resp, error) {
+	ctx, span := tracing.StartSpanFromContext(ctx, "az-amqp-common.rpc.Call")
+	defer span.End()
+
+	var resp Resp
+
+	body, properties, err := encodeRequest(req)
+	if err != nil {
+		return resp, err
+	}
+	properties[operationKey] = operation
+
+	res, err := link.RPC(ctx, &amqp.Message{
+		Value:                 body,
+		ApplicationProperties: properties,
+	})
+	if err != nil {
+		return resp, err
+	}
+
+	if res.Code < 200 || res.Code >= 300 {
+		return resp, newStatusError(res)
+	}
+
+	if res.Message == nil {
+		return resp, nil
+	}
+
+	if err := codec.Unmarshal(res.Message.Value, &resp); err != nil {
+		return resp, err
+	}
+	return resp, nil
+}
+
+// encodeRequest converts a request into the value of an AMQP message and its application properties
+func encodeRequest(req interface{}) (map[string]interface{}, map[string]interface{}, error) {
+	encoded, err := codec.Marshal(req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	properties := map[string]interface{}{}
+	if encoded == nil {
+		return map[string]interface{}{}, properties, nil
+	}
+
+	body, ok := encoded.(map[string]interface{})
+	if !ok {
+		return nil, nil, fmt.Errorf("cannot encode %T as a request", req)
+	}
+
+	t := reflect.TypeOf(req)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t.Kind() == reflect.Struct {
+		for _, f := range codec.Fields(t) {
+			if value, ok := body[f.Name]; ok && f.HasOption("property") {
+				properties[f.Name] = value
+				delete(body, f.Name)
+			}
+		}
+	}
+	return body, properties, nil
+}
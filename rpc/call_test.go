@@ -0,0 +1,126 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-amqp"
+	"github.com/stretchr/testify/require"
+)
+
+type (
+	peekRequest struct {
+		FromSequenceNumber int64  `amqp:"from-sequence-number"`
+		MessageCount       int32  `amqp:"message-count"`
+		SessionID          string `amqp:"session-id,omitempty"`
+		Timeout            uint32 `amqp:"server-timeout,property"`
+		Ignored            string `amqp:"-"`
+	}
+
+	peekedMessage struct {
+		Message []byte `amqp:"message"`
+	}
+
+	peekResponse struct {
+		Messages []peekedMessage `amqp:"messages"`
+		Count    int64           `amqp:"count"`
+		Next     *int64          `amqp:"next"`
+	}
+)
+
+func TestCall(t *testing.T) {
+	broker := newFakeBroker(func(req *amqp.Message) *amqp.Message {
+		res := statusResponse(200, "OK")
+		res.Value = map[string]interface{}{
+			"messages": []interface{}{
+				map[string]interface{}{"message": []byte("hello")},
+			},
+			"count": int32(1),
+		}
+		return res
+	})
+	link := newTestLink(t, broker)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := Call[peekRequest, peekResponse](ctx, link, "com.microsoft:peek-message", peekRequest{
+		FromSequenceNumber: 10,
+		MessageCount:       1,
+		Timeout:            5000,
+		Ignored:            "not sent",
+	})
+	require.NoError(t, err)
+	require.Equal(t, peekResponse{
+		Messages: []peekedMessage{{Message: []byte("hello")}},
+		Count:    1,
+	}, res)
+
+	sent := broker.Sent()[0]
+	require.Equal(t, "com.microsoft:peek-message", sent.ApplicationProperties["operation"])
+	require.Equal(t, uint32(5000), sent.ApplicationProperties["server-timeout"])
+	require.Equal(t, map[string]interface{}{
+		"from-sequence-number": int64(10),
+		"message-count":        int32(1),
+	}, sent.Value)
+}
+
+func TestCallWithMaps(t *testing.T) {
+	broker := newFakeBroker(func(req *amqp.Message) *amqp.Message {
+		res := statusResponse(200, "OK")
+		res.Value = req.Value
+		return res
+	})
+	link := newTestLink(t, broker)
+
+	res, err := Call[map[string]interface{}, map[string]int64](context.Background(), link, "echo", map[string]interface{}{
+		"a": int32(1),
+		"b": uint8(2),
+	})
+	require.NoError(t, err)
+	require.Equal(t, map[string]int64{"a": 1, "b": 2}, res)
+}
+
+func TestCallStatusError(t *testing.T) {
+	link := newTestLink(t, newFakeBroker(func(req *amqp.Message) *amqp.Message {
+		return statusResponse(404, "no such entity")
+	}))
+
+	_, err := Call[struct{}, struct{}](context.Background(), link, "op", struct{}{})
+	var statusErr *StatusError
+	require.ErrorAs(t, err, &statusErr)
+	require.Equal(t, 404, statusErr.Code)
+}
+
+func TestCallDecodeError(t *testing.T) {
+	link := newTestLink(t, newFakeBroker(func(req *amqp.Message) *amqp.Message {
+		res := statusResponse(200, "OK")
+		res.Value = map[string]interface{}{
+			"messages": []interface{}{
+				map[string]interface{}{"message": "not bytes"},
+			},
+		}
+		return res
+	}))
+
+	_, err := Call[struct{}, peekResponse](context.Background(), link, "op", struct{}{})
+	var decodeErr *DecodeError
+	require.ErrorAs(t, err, &decodeErr)
+	require.Equal(t, "messages[0].message", decodeErr.Field)
+	require.Equal(t, "not bytes", decodeErr.Value)
+}
+
+func TestCallDecodeOverflow(t *testing.T) {
+	link := newTestLink(t, newFakeBroker(func(req *amqp.Message) *amqp.Message {
+		res := statusResponse(200, "OK")
+		res.Value = int64(1 << 40)
+		return res
+	}))
+
+	_, err := Call[struct{}, int32](context.Background(), link, "op", struct{}{})
+	var decodeErr *DecodeError
+	require.ErrorAs(t, err, &decodeErr)
+	require.Error(t, errors.Unwrap(decodeErr))
+}
@@ -0,0 +1,410 @@
+package rpc
+
+//	MIT License
+//
+//	Copyright (c) Microsoft Corporation. All rights reserved.
+//
+//	Permission is hereby granted, free of charge, to any person obtaining a copy
+//	of this software and associated documentation files (the "Software"), to deal
+//	in the Software without restriction, including without limitation the rights
+//	to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//	copies of the Software, and to permit persons to whom the Software is
+//	furnished to do so, subject to the following conditions:
+//
+//	The above copyright notice and this permission notice shall be included in all
+//	copies or substantial portions of the Software.
+//
+//	THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//	AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//	LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//	OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//	SOFTWARE
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/devigned/tab"
+
+	"github.com/Azure/azure-amqp-common-go/v4/internal/tracing"
+	"github.com/Azure/go-amqp"
+)
+
+const (
+	defaultClientMaxLinks    = 64
+	defaultClientIdleTimeout = 5 * time.Minute
+)
+
+// ErrClientClosed is returned by requests made on a Client after it has been closed
+var ErrClientClosed = errors.New("rpc: client is closed")
+
+type (
+	// Client makes requests to many addresses over a single AMQP session. It creates a Link for an address the first
+	// time a request is made to it and keeps it for later requests, closing the least recently used links once there
+	// are more than the configured maximum, and links which have been idle for longer than the idle timeout. Idle
+	// links are closed in the background until the Client is closed. A Client is safe for concurrent use.
+	Client struct {
+		session     *amqp.Session
+		ownsSession bool
+		linkOpts    []LinkOption
+		maxLinks    int
+		idleTimeout time.Duration
+
+		mu     sync.Mutex
+		links  map[string]*clientLink
+		lru    *list.List // of *clientLink, most recently used first
+		closed bool
+
+		stopSweep  chan struct{}
+		sweepEnded chan struct{}
+
+		// for unit tests
+		newLink func(ctx context.Context, address string) (*Link, error)
+		now     func() time.Time
+	}
+
+	// ClientOption provides a way to customize the construction of a Client
+	ClientOption func(c *Client) error
+
+	// clientLink is a Link cached by a Client, along with the number of requests using it
+	clientLink struct {
+		address  string
+		link     *Link
+		err      error
+		ready    chan struct{}
+		refs     int
+		lastUsed time.Time
+		elem     *list.Element
+	}
+)
+
+// ClientWithLinkOptions configures the options every Link of a Client is built with
+func ClientWithLinkOptions(opts ...LinkOption) ClientOption {
+	return func(c *Client) error {
+		c.linkOpts = append(c.linkOpts, opts...)
+		return nil
+	}
+}
+
+// ClientWithMaxLinks configures how many links a Client keeps open. The default is 64. Links in use by a request are
+// never closed, so a Client may briefly have more.
+func ClientWithMaxLinks(max int) ClientOption {
+	return func(c *Client) error {
+		if max <= 0 {
+			return errors.New("max links must be positive")
+		}
+		c.maxLinks = max
+		return nil
+	}
+}
+
+// ClientWithIdleTimeout configures how long a Client keeps a link which is not used. The default is 5 minutes, and
+// zero keeps links until they are evicted to make room for others.
+func ClientWithIdleTimeout(timeout time.Duration) ClientOption {
+	return func(c *Client) error {
+		if timeout < 0 {
+			return errors.New("idle timeout must not be negative")
+		}
+		c.idleTimeout = timeout
+		return nil
+	}
+}
+
+// NewClient builds a Client with a new session on conn. The session is closed when the Client is.
+func NewClient(ctx context.Context, conn *amqp.Conn, opts ...ClientOption) (*Client, error) {
+	session, err := conn.NewSession(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := newClient(session, opts...)
+	if err != nil {
+		closeCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+
+		_ = session.Close(closeCtx)
+		return nil, err
+	}
+	client.ownsSession = true
+	return client, nil
+}
+
+// NewClientWithSession builds a Client which reuses an existing session. The session is left open when the Client is
+// closed.
+func NewClientWithSession(session *amqp.Session, opts ...ClientOption) (*Client, error) {
+	return newClient(session, opts...)
+}
+
+func newClient(session *amqp.Session, opts ...ClientOption) (*Client, error) {
+	c := &Client{
+		session:     session,
+		maxLinks:    defaultClientMaxLinks,
+		idleTimeout: defaultClientIdleTimeout,
+		links:       map[string]*clientLink{},
+		lru:         list.New(),
+		now:         time.Now,
+	}
+	c.newLink = c.attachLink
+
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// RPC sends a request to address and waits on a response for that request
+func (c *Client) RPC(ctx context.Context, address string, msg *amqp.Message) (*Response, error) {
+	ctx, span := tracing.StartSpanFromContext(ctx, "az-amqp-common.rpc.Client.RPC")
+	defer span.End()
+
+	link, release, err := c.acquire(ctx, address)
+	if err != nil {
+		tab.For(ctx).Error(err)
+		return nil, err
+	}
+
+	res, err := link.RPC(ctx, msg)
+	release(err)
+	return res, err
+}
+
+// RetryableRPC sends a request to address, attempting it up to times with delay between attempts
+func (c *Client) RetryableRPC(ctx context.Context, address string, times int, delay time.Duration, msg *amqp.Message) (*Response, error) {
+	return c.RetryableRPCWithPolicy(ctx, address, FixedRetryPolicy{MaxAttempts: times, Delay: delay}, msg)
+}
+
+// RetryableRPCWithPolicy sends a request to address, retrying it as long as policy allows. If policy is nil, the
+// policy configured on the links of the Client is used.
+func (c *Client) RetryableRPCWithPolicy(ctx context.Context, address string, policy RetryPolicy, msg *amqp.Message) (*Response, error) {
+	ctx, span := tracing.StartSpanFromContext(ctx, "az-amqp-common.rpc.Client.RetryableRPC")
+	defer span.End()
+
+	link, release, err := c.acquire(ctx, address)
+	if err != nil {
+		tab.For(ctx).Error(err)
+		return nil, err
+	}
+
+	res, err := link.RetryableRPCWithPolicy(ctx, policy, msg)
+	release(err)
+	return res, err
+}
+
+// Len returns how many links the Client has open
+func (c *Client) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.links)
+}
+
+// Close closes every link of the Client, and its session if the Client created it. Requests still in progress fail.
+func (c *Client) Close(ctx context.Context) error {
+	ctx, span := tracing.StartSpanFromContext(ctx, "az-amqp-common.rpc.Client.Close")
+	defer span.End()
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	if c.stopSweep != nil {
+		close(c.stopSweep)
+	}
+
+	entries := make([]*clientLink, 0, len(c.links))
+	for _, entry := range c.links {
+		entries = append(entries, entry)
+	}
+	c.links = map[string]*clientLink{}
+	c.lru.Init()
+	c.mu.Unlock()
+
+	if c.sweepEnded != nil {
+		<-c.sweepEnded
+	}
+
+	var firstErr error
+	for _, entry := range entries {
+		<-entry.ready
+		if entry.link == nil {
+			continue
+		}
+		if err := entry.link.Close(ctx); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	if c.ownsSession && c.session != nil {
+		if err := c.session.Close(ctx); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// acquire returns the link for address, creating it if needed. release must be called with the outcome of the
+// request once the link is no longer used.
+func (c *Client) acquire(ctx context.Context, address string) (*Link, func(err error), error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, nil, ErrClientClosed
+	}
+	if c.idleTimeout > 0 && c.stopSweep == nil {
+		c.stopSweep = make(chan struct{})
+		c.sweepEnded = make(chan struct{})
+		go c.sweep(c.idleTimeout / 2)
+	}
+
+	entry, ok := c.links[address]
+	if !ok {
+		entry = &clientLink{
+			address: address,
+			ready:   make(chan struct{}),
+		}
+		c.links[address] = entry
+		entry.elem = c.lru.PushFront(entry)
+	} else {
+		c.lru.MoveToFront(entry.elem)
+	}
+	entry.refs++
+	entry.lastUsed = c.now()
+	evicted := c.evictLocked()
+	c.mu.Unlock()
+
+	c.closeLinks(evicted)
+
+	if !ok {
+		entry.link, entry.err = c.newLink(ctx, address)
+		close(entry.ready)
+	}
+
+	select {
+	case <-entry.ready:
+	case <-ctx.Done():
+		c.release(entry, nil)
+		return nil, nil, ctx.Err()
+	}
+
+	if entry.err != nil {
+		c.release(entry, entry.err)
+		return nil, nil, entry.err
+	}
+
+	return entry.link, func(err error) { c.release(entry, err) }, nil
+}
+
+// release gives up a reference to entry. A link which failed to attach, or which has been closed underneath the
+// Client, is forgotten so the next request to its address creates a new one.
+func (c *Client) release(entry *clientLink, err error) {
+	c.mu.Lock()
+	entry.refs--
+	entry.lastUsed = c.now()
+
+	var dead []*clientLink
+	if err != nil && (entry.link == nil || IsDetached(err)) && c.links[entry.address] == entry {
+		c.removeLocked(entry)
+		if entry.link != nil {
+			dead = append(dead, entry)
+		}
+	}
+	dead = append(dead, c.evictLocked()...)
+	c.mu.Unlock()
+
+	c.closeLinks(dead)
+}
+
+// evictLocked removes the links which are idle for too long, and the least recently used links beyond the maximum,
+// from the cache and returns them. Links in use are kept. c.mu must be held.
+func (c *Client) evictLocked() []*clientLink {
+	var evicted []*clientLink
+	now := c.now()
+	excess := len(c.links) - c.maxLinks
+
+	for elem := c.lru.Back(); elem != nil; {
+		entry := elem.Value.(*clientLink)
+		elem = elem.Prev()
+
+		if entry.refs > 0 {
+			continue
+		}
+
+		idle := c.idleTimeout > 0 && now.Sub(entry.lastUsed) >= c.idleTimeout
+		if !idle && excess <= 0 {
+			continue
+		}
+
+		c.removeLocked(entry)
+		evicted = append(evicted, entry)
+		excess--
+	}
+	return evicted
+}
+
+// sweep closes idle links every interval until the Client is closed
+func (c *Client) sweep(interval time.Duration) {
+	defer close(c.sweepEnded)
+
+	if interval <= 0 {
+		interval = c.idleTimeout
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stopSweep:
+			return
+		case <-ticker.C:
+		}
+
+		c.mu.Lock()
+		if c.closed {
+			c.mu.Unlock()
+			return
+		}
+		evicted := c.evictLocked()
+		c.mu.Unlock()
+
+		c.closeLinks(evicted)
+	}
+}
+
+func (c *Client) removeLocked(entry *clientLink) {
+	delete(c.links, entry.address)
+	c.lru.Remove(entry.elem)
+}
+
+// closeLinks closes the links of entries which have been removed from the cache
+func (c *Client) closeLinks(entries []*clientLink) {
+	for _, entry := range entries {
+		if entry.link == nil {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := entry.link.Close(ctx); err != nil {
+			tab.For(ctx).Debug("error closing evicted rpc link: " + err.Error())
+		}
+		cancel()
+	}
+}
+
+// attachLink builds a Link to address on the session of the Client
+func (c *Client) attachLink(ctx context.Context, address string) (*Link, error) {
+	link, err := NewLinkWithSession(ctx, c.session, address, c.linkOpts...)
+	if err != nil {
+		return nil, err
+	}
+	link.sharedSession = true
+	return link, nil
+}
@@ -0,0 +1,213 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-amqp"
+	"github.com/stretchr/testify/require"
+)
+
+// testClient is a Client whose links send their requests to a fakeBroker per address
+type testClient struct {
+	*Client
+
+	mu      sync.Mutex
+	brokers map[string][]*fakeBroker
+	now     time.Time
+}
+
+func newTestClient(t *testing.T, opts ...ClientOption) *testClient {
+	client, err := newClient(nil, opts...)
+	require.NoError(t, err)
+
+	tc := &testClient{
+		Client:  client,
+		brokers: map[string][]*fakeBroker{},
+		now:     time.Now(),
+	}
+	client.newLink = func(ctx context.Context, address string) (*Link, error) {
+		broker := okBroker()
+		tc.mu.Lock()
+		tc.brokers[address] = append(tc.brokers[address], broker)
+		tc.mu.Unlock()
+		return newTestLink(t, broker), nil
+	}
+	client.now = func() time.Time {
+		tc.mu.Lock()
+		defer tc.mu.Unlock()
+		return tc.now
+	}
+	return tc
+}
+
+func (tc *testClient) created(address string) []*fakeBroker {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	return tc.brokers[address]
+}
+
+func (tc *testClient) advance(d time.Duration) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.now = tc.now.Add(d)
+}
+
+func isClosed(broker *fakeBroker) bool {
+	select {
+	case <-broker.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestClientReusesLinks(t *testing.T) {
+	client := newTestClient(t)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		_, err := client.RPC(ctx, "queue/$management", &amqp.Message{})
+		require.NoError(t, err)
+	}
+	_, err := client.RPC(ctx, "topic/subscriptions/sub/$management", &amqp.Message{})
+	require.NoError(t, err)
+
+	require.Len(t, client.created("queue/$management"), 1)
+	require.Len(t, client.created("queue/$management")[0].Sent(), 3)
+	require.Len(t, client.created("topic/subscriptions/sub/$management"), 1)
+	require.Equal(t, 2, client.Len())
+}
+
+func TestClientEvictsLeastRecentlyUsed(t *testing.T) {
+	client := newTestClient(t, ClientWithMaxLinks(2))
+	ctx := context.Background()
+
+	for _, address := range []string{"a", "b", "a", "c"} {
+		_, err := client.RPC(ctx, address, &amqp.Message{})
+		require.NoError(t, err)
+	}
+
+	require.Equal(t, 2, client.Len())
+	require.True(t, isClosed(client.created("b")[0]), "b was the least recently used")
+	require.False(t, isClosed(client.created("a")[0]))
+	require.False(t, isClosed(client.created("c")[0]))
+}
+
+func TestClientEvictsIdleLinks(t *testing.T) {
+	client := newTestClient(t, ClientWithIdleTimeout(time.Minute))
+	ctx := context.Background()
+
+	_, err := client.RPC(ctx, "a", &amqp.Message{})
+	require.NoError(t, err)
+
+	client.advance(2 * time.Minute)
+
+	_, err = client.RPC(ctx, "b", &amqp.Message{})
+	require.NoError(t, err)
+	require.Equal(t, 1, client.Len())
+	require.True(t, isClosed(client.created("a")[0]))
+
+	// a new link is created the next time the address is used
+	_, err = client.RPC(ctx, "a", &amqp.Message{})
+	require.NoError(t, err)
+	require.Len(t, client.created("a"), 2)
+}
+
+func TestClientSweepsIdleLinksInBackground(t *testing.T) {
+	client := newTestClient(t, ClientWithIdleTimeout(20*time.Millisecond))
+	ctx := context.Background()
+
+	_, err := client.RPC(ctx, "a", &amqp.Message{})
+	require.NoError(t, err)
+
+	client.advance(time.Minute)
+
+	require.Eventually(t, func() bool { return client.Len() == 0 }, 5*time.Second, 5*time.Millisecond)
+	require.True(t, isClosed(client.created("a")[0]))
+	require.NoError(t, client.Close(ctx))
+
+	select {
+	case <-client.sweepEnded:
+	default:
+		t.Fatal("the sweep should be stopped once the client is closed")
+	}
+}
+
+func TestClientRetryableRPC(t *testing.T) {
+	client := newTestClient(t)
+	ctx := context.Background()
+
+	_, err := client.RetryableRPC(ctx, "a", 3, 0, &amqp.Message{})
+	require.NoError(t, err)
+	require.Len(t, client.created("a"), 1)
+	require.Len(t, client.created("a")[0].Sent(), 1)
+	require.NoError(t, client.Close(ctx))
+}
+
+func TestClientCreatesOneLinkPerAddressConcurrently(t *testing.T) {
+	client := newTestClient(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 50)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := client.RPC(ctx, "queue/$management", &amqp.Message{})
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		require.NoError(t, err)
+	}
+	require.Len(t, client.created("queue/$management"), 1)
+	require.Len(t, client.created("queue/$management")[0].Sent(), 50)
+}
+
+func TestClientDoesNotCacheFailedLinks(t *testing.T) {
+	client := newTestClient(t)
+	attachErr := errors.New("attach failed")
+	newLink := client.newLink
+	client.newLink = func(ctx context.Context, address string) (*Link, error) {
+		return nil, attachErr
+	}
+
+	_, err := client.RPC(context.Background(), "a", &amqp.Message{})
+	require.ErrorIs(t, err, attachErr)
+	require.Equal(t, 0, client.Len())
+
+	client.newLink = newLink
+	_, err = client.RPC(context.Background(), "a", &amqp.Message{})
+	require.NoError(t, err)
+}
+
+func TestClientClose(t *testing.T) {
+	client := newTestClient(t)
+	ctx := context.Background()
+
+	_, err := client.RPC(ctx, "a", &amqp.Message{})
+	require.NoError(t, err)
+
+	require.NoError(t, client.Close(ctx))
+	require.True(t, isClosed(client.created("a")[0]))
+
+	_, err = client.RPC(ctx, "a", &amqp.Message{})
+	require.ErrorIs(t, err, ErrClientClosed)
+}
+
+func TestClientOptionsValidate(t *testing.T) {
+	_, err := newClient(nil, ClientWithMaxLinks(0))
+	require.Error(t, err)
+
+	_, err = newClient(nil, ClientWithIdleTimeout(-time.Second))
+	require.Error(t, err)
+}
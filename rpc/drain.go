@@ -0,0 +1,96 @@
+package rpc
+
+//	MIT License
+//
+//	Copyright (c) Microsoft Corporation. All rights reserved.
+//
+//	Permission is hereby granted, free of charge, to any person obtaining a copy
+//	of this software and associated documentation files (the "Software"), to deal
+//	in the Software without restriction, including without limitation the rights
+//	to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//	copies of the Software, and to permit persons to whom the Software is
+//	furnished to do so, subject to the following conditions:
+//
+//	The above copyright notice and this permission notice shall be included in all
+//	copies or substantial portions of the Software.
+//
+//	THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//	AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//	LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//	OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//	SOFTWARE
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/devigned/tab"
+
+	"github.com/Azure/azure-amqp-common-go/v4/internal/tracing"
+)
+
+// CloseWithDrain stops the link from accepting new requests and waits for the requests already in flight to receive
+// and settle their responses, until ctx is done. It then closes the receiver, sender and session, in that order. The
+// message IDs of the requests which were still waiting for a response when the link closed are returned as abandoned;
+// their callers receive ErrLinkClosed.
+func (l *Link) CloseWithDrain(ctx context.Context) ([]string, error) {
+	ctx, span := tracing.StartSpanFromContext(ctx, "az-amqp-common.rpc.CloseWithDrain")
+	defer span.End()
+
+	l.responseMu.Lock()
+	l.closed = true
+	if l.drained == nil {
+		l.drained = make(chan struct{})
+	}
+	drained := l.drained
+	l.checkDrainedLocked()
+	l.responseMu.Unlock()
+
+	select {
+	case <-drained:
+	case <-ctx.Done():
+	}
+
+	l.responseMu.Lock()
+	abandoned := make([]string, 0, len(l.responseMap))
+	for messageID := range l.responseMap {
+		abandoned = append(abandoned, messageID)
+	}
+	l.responseMu.Unlock()
+	sort.Strings(abandoned)
+
+	if len(abandoned) > 0 {
+		tab.For(ctx).Info("rpc link closed with abandoned requests", tab.Int64Attribute("abandoned", int64(len(abandoned))))
+	}
+
+	// the links still need closing once the drain has run out of time
+	closeCtx := ctx
+	if ctx.Err() != nil {
+		var cancel context.CancelFunc
+		closeCtx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+	}
+
+	if err := l.Close(closeCtx); err != nil {
+		tab.For(ctx).Error(err)
+		return abandoned, err
+	}
+	return abandoned, nil
+}
+
+// checkDrainedLocked signals a drain in progress once no request is in flight, which includes settling its response.
+// responseMu must be held.
+func (l *Link) checkDrainedLocked() {
+	if l.drained == nil || len(l.responseMap) > 0 || l.stats.InFlight > 0 {
+		return
+	}
+
+	select {
+	case <-l.drained:
+	default:
+		close(l.drained)
+	}
+}
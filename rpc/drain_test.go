@@ -0,0 +1,117 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-amqp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCloseWithDrainWaitsForResponses(t *testing.T) {
+	broker := newFakeBroker(nil)
+	link := newTestLink(t, broker)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := link.RPC(ctx, &amqp.Message{})
+		result <- err
+	}()
+	require.Eventually(t, func() bool { return len(broker.Sent()) == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	type drainResult struct {
+		abandoned []string
+		err       error
+	}
+	drainCh := make(chan drainResult, 1)
+	go func() {
+		abandoned, err := link.CloseWithDrain(ctx)
+		drainCh <- drainResult{abandoned, err}
+	}()
+
+	// new requests are refused while draining
+	require.Eventually(t, func() bool {
+		_, err := link.RPC(ctx, &amqp.Message{})
+		return err == ErrLinkClosed
+	}, 5*time.Second, 10*time.Millisecond)
+
+	respondTo(broker, broker.Sent()[0])
+	require.NoError(t, <-result)
+
+	drain := <-drainCh
+	require.NoError(t, drain.err)
+	require.Empty(t, drain.abandoned)
+}
+
+func TestCloseWithDrainWaitsForAccept(t *testing.T) {
+	broker := newFakeBroker(nil)
+	link := newTestLink(t, broker)
+
+	accepting := make(chan struct{})
+	link.messageAccept = func(ctx context.Context, message *amqp.Message) error {
+		close(accepting)
+		time.Sleep(50 * time.Millisecond)
+
+		select {
+		case <-broker.closed:
+			return errors.New("receiver closed before the response was accepted")
+		default:
+			return nil
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := link.RPC(ctx, &amqp.Message{})
+		result <- err
+	}()
+	require.Eventually(t, func() bool { return len(broker.Sent()) == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	respondTo(broker, broker.Sent()[0])
+	<-accepting
+
+	abandoned, err := link.CloseWithDrain(ctx)
+	require.NoError(t, err)
+	require.Empty(t, abandoned)
+	require.NoError(t, <-result)
+}
+
+func TestCloseWithDrainAbandonsRequests(t *testing.T) {
+	broker := newFakeBroker(nil)
+	link := newTestLink(t, broker)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := link.RPC(ctx, &amqp.Message{})
+		result <- err
+	}()
+	require.Eventually(t, func() bool { return len(broker.Sent()) == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	drainCtx, drainCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer drainCancel()
+
+	abandoned, err := link.CloseWithDrain(drainCtx)
+	require.NoError(t, err)
+	require.Equal(t, []string{broker.Sent()[0].Properties.MessageID.(string)}, abandoned)
+
+	require.ErrorIs(t, <-result, ErrLinkClosed)
+}
+
+func TestCloseWithDrainWithoutRequests(t *testing.T) {
+	link := newTestLink(t, newFakeBroker(nil))
+
+	abandoned, err := link.CloseWithDrain(context.Background())
+	require.NoError(t, err)
+	require.Empty(t, abandoned)
+}
@@ -0,0 +1,87 @@
+package rpc
+
+//	MIT License
+//
+//	Copyright (c) Microsoft Corporation. All rights reserved.
+//
+//	Permission is hereby granted, free of charge, to any person obtaining a copy
+//	of this software and associated documentation files (the "Software"), to deal
+//	in the Software without restriction, including without limitation the rights
+//	to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//	copies of the Software, and to permit persons to whom the Software is
+//	furnished to do so, subject to the following conditions:
+//
+//	The above copyright notice and this permission notice shall be included in all
+//	copies or substantial portions of the Software.
+//
+//	THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//	AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//	LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//	OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//	SOFTWARE
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Azure/go-amqp"
+)
+
+const errorConditionKey = "error-condition"
+
+type (
+	// StatusError is returned when a request completes with a status code outside of the 2xx range
+	StatusError struct {
+		// Code is the status code of the response
+		Code int
+		// Description is the status description of the response
+		Description string
+		// Condition is the error-condition application property of the response, if it has one
+		Condition string
+		// Message is the raw response
+		Message *amqp.Message
+		// Throttled is true if the service rejected the request because the client is sending too many, either with
+		// a 429 status code or the com.microsoft:server-busy condition
+		Throttled bool
+		// RetryAfter is how long the service asked the client to wait before retrying, if it did
+		RetryAfter time.Duration
+	}
+)
+
+// newStatusError builds a StatusError from a response
+func newStatusError(res *Response) *StatusError {
+	statusErr := &StatusError{
+		Code:        res.Code,
+		Description: res.Description,
+		Message:     res.Message,
+	}
+
+	if res.Message != nil {
+		if condition, ok := res.Message.ApplicationProperties[errorConditionKey]; ok && condition != nil {
+			statusErr.Condition = fmt.Sprintf("%v", condition)
+		}
+	}
+
+	statusErr.Throttled = isThrottled(statusErr.Code, statusErr.Condition)
+	if statusErr.Throttled && res.Message != nil {
+		statusErr.RetryAfter = retryAfter(res.Message.ApplicationProperties)
+	}
+	return statusErr
+}
+
+// Error implementation for StatusError
+func (e *StatusError) Error() string {
+	if e.Condition != "" {
+		return fmt.Sprintf("status code %d (%s) and description: %s", e.Code, e.Condition, e.Description)
+	}
+	return fmt.Sprintf("status code %d and description: %s", e.Code, e.Description)
+}
+
+// Retryable returns true if the request may succeed if it is sent again. Server errors, request timeouts and
+// throttling are retryable; other client errors are not.
+func (e *StatusError) Retryable() bool {
+	return e.Code >= 500 || e.Code == http.StatusRequestTimeout || e.Throttled
+}
@@ -0,0 +1,49 @@
+package rpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-amqp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryableRPCFailsFastOnClientErrors(t *testing.T) {
+	for _, code := range []int32{400, 401, 404, 410} {
+		broker := newFakeBroker(func(req *amqp.Message) *amqp.Message {
+			res := statusResponse(code, "entity not found")
+			res.ApplicationProperties["error-condition"] = "com.microsoft:entity-not-found"
+			return res
+		})
+
+		link := newTestLink(t, broker)
+		_, err := link.RetryableRPC(context.Background(), 3, time.Millisecond, &amqp.Message{})
+
+		var statusErr *StatusError
+		require.ErrorAs(t, err, &statusErr)
+		require.Equal(t, int(code), statusErr.Code)
+		require.Equal(t, "entity not found", statusErr.Description)
+		require.Equal(t, "com.microsoft:entity-not-found", statusErr.Condition)
+		require.NotNil(t, statusErr.Message)
+		require.False(t, statusErr.Retryable())
+		require.Len(t, broker.Sent(), 1, "code %d is not retried", code)
+	}
+}
+
+func TestRetryableRPCRetriesTransientStatusCodes(t *testing.T) {
+	for _, code := range []int32{408, 429, 500, 503} {
+		broker := newFakeBroker(func(req *amqp.Message) *amqp.Message {
+			return statusResponse(code, "try again")
+		})
+
+		link := newTestLink(t, broker)
+		_, err := link.RetryableRPC(context.Background(), 3, time.Millisecond, &amqp.Message{})
+
+		var statusErr *StatusError
+		require.ErrorAs(t, err, &statusErr)
+		require.Equal(t, int(code), statusErr.Code)
+		require.True(t, statusErr.Retryable())
+		require.Len(t, broker.Sent(), 3, "code %d is retried", code)
+	}
+}
@@ -0,0 +1,57 @@
+package rpc
+
+//	MIT License
+//
+//	Copyright (c) Microsoft Corporation. All rights reserved.
+//
+//	Permission is hereby granted, free of charge, to any person obtaining a copy
+//	of this software and associated documentation files (the "Software"), to deal
+//	in the Software without restriction, including without limitation the rights
+//	to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//	copies of the Software, and to permit persons to whom the Software is
+//	furnished to do so, subject to the following conditions:
+//
+//	The above copyright notice and this permission notice shall be included in all
+//	copies or substantial portions of the Software.
+//
+//	THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//	AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//	LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//	OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//	SOFTWARE
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Azure/go-amqp"
+)
+
+// ErrRequestInFlight is returned when a request is made with the message ID of a request which is still waiting for
+// its response, for instance when the same idempotency key is used concurrently
+var ErrRequestInFlight = errors.New("rpc: a request with the same message ID is already in flight")
+
+// LinkWithStableMessageIDs configures a Link to send every attempt of a request made with RetryableRPC or
+// RetryableRPCWithPolicy with the same message ID
+func LinkWithStableMessageIDs() LinkOption {
+	return func(l *Link) error {
+		l.stableMessageIDs = true
+		return nil
+	}
+}
+
+// RPCWithIdempotencyKey sends a request with key as its message ID. Services which detect duplicate requests by
+// message ID then apply the request once, however many times it is sent. Keys must be unique per operation; an empty
+// key is ignored. The key applies to this request only, not to requests made by interceptors or with the same context.
+func (l *Link) RPCWithIdempotencyKey(ctx context.Context, key string, msg *amqp.Message) (*Response, error) {
+	return l.send(ctx, key, msg)
+}
+
+// RetryableRPCWithIdempotencyKey retries a request as long as policy allows, sending every attempt with key as its
+// message ID. If key is empty it behaves like RetryableRPCWithPolicy. If policy is nil, the policy configured with
+// LinkWithRetryPolicy is used.
+func (l *Link) RetryableRPCWithIdempotencyKey(ctx context.Context, key string, policy RetryPolicy, msg *amqp.Message) (*Response, error) {
+	return l.retryableRPC(ctx, key, policy, msg)
+}
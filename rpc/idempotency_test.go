@@ -0,0 +1,157 @@
+package rpc
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-amqp"
+	"github.com/stretchr/testify/require"
+)
+
+func flakyBroker(failures int32) *fakeBroker {
+	var calls int32
+	return newFakeBroker(func(req *amqp.Message) *amqp.Message {
+		if atomic.AddInt32(&calls, 1) <= failures {
+			return statusResponse(503, "try again")
+		}
+		return statusResponse(200, "OK")
+	})
+}
+
+func messageIDs(msgs []*amqp.Message) []interface{} {
+	ids := make([]interface{}, len(msgs))
+	for i, msg := range msgs {
+		ids[i] = msg.Properties.MessageID
+	}
+	return ids
+}
+
+func TestRetryableRPCWithStableMessageIDs(t *testing.T) {
+	broker := flakyBroker(2)
+	link := newTestLink(t, broker, LinkWithStableMessageIDs())
+
+	_, err := link.RetryableRPC(context.Background(), 3, time.Millisecond, &amqp.Message{})
+	require.NoError(t, err)
+
+	ids := messageIDs(broker.Sent())
+	require.Len(t, ids, 3)
+	require.Equal(t, ids[0], ids[1])
+	require.Equal(t, ids[0], ids[2])
+
+	// a new request gets a new message ID
+	_, err = link.RetryableRPC(context.Background(), 3, time.Millisecond, &amqp.Message{})
+	require.NoError(t, err)
+	require.NotEqual(t, ids[0], broker.Sent()[3].Properties.MessageID)
+}
+
+func TestRetryableRPCChangesMessageIDsByDefault(t *testing.T) {
+	broker := flakyBroker(1)
+	link := newTestLink(t, broker)
+
+	_, err := link.RetryableRPC(context.Background(), 2, time.Millisecond, &amqp.Message{})
+	require.NoError(t, err)
+
+	ids := messageIDs(broker.Sent())
+	require.Len(t, ids, 2)
+	require.NotEqual(t, ids[0], ids[1])
+}
+
+func TestRetryableRPCWithIdempotencyKey(t *testing.T) {
+	broker := flakyBroker(1)
+	link := newTestLink(t, broker)
+	policy := FixedRetryPolicy{MaxAttempts: 2, Delay: time.Millisecond}
+
+	_, err := link.RetryableRPCWithIdempotencyKey(context.Background(), "schedule-42", policy, &amqp.Message{})
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{"schedule-42", "schedule-42"}, messageIDs(broker.Sent()))
+
+	// an empty key is ignored
+	_, err = link.RPCWithIdempotencyKey(context.Background(), "", &amqp.Message{})
+	require.NoError(t, err)
+	require.NotEqual(t, "", broker.Sent()[2].Properties.MessageID)
+}
+
+func TestIdempotencyKeyAppliesToOneRequest(t *testing.T) {
+	broker := okBroker()
+	var link *Link
+	nested := true
+	link = newTestLink(t, broker, LinkWithInterceptors(func(ctx context.Context, msg *amqp.Message, invoker Invoker) (*Response, error) {
+		if nested {
+			// a request made while handling the keyed request, with the same context, gets its own message ID
+			nested = false
+			if _, err := link.RPC(ctx, &amqp.Message{}); err != nil {
+				return nil, err
+			}
+		}
+		return invoker(ctx, msg)
+	}))
+	ctx := context.Background()
+
+	_, err := link.RPCWithIdempotencyKey(ctx, "key", &amqp.Message{})
+	require.NoError(t, err)
+
+	// a later request with the same context also gets its own message ID
+	_, err = link.RPC(ctx, &amqp.Message{})
+	require.NoError(t, err)
+
+	ids := messageIDs(broker.Sent())
+	require.Len(t, ids, 3)
+	require.NotEqual(t, "key", ids[0])
+	require.Equal(t, "key", ids[1])
+	require.NotEqual(t, "key", ids[2])
+}
+
+func TestIdempotencyKeyInFlight(t *testing.T) {
+	broker := newFakeBroker(nil)
+	link := newTestLink(t, broker)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	go func() {
+		_, _ = link.RPCWithIdempotencyKey(ctx, "key", &amqp.Message{})
+	}()
+	require.Eventually(t, func() bool { return len(broker.Sent()) == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	_, err := link.RPCWithIdempotencyKey(ctx, "key", &amqp.Message{})
+	require.ErrorIs(t, err, ErrRequestInFlight)
+	require.Len(t, broker.Sent(), 1)
+
+	// a concurrent request with the same context but without the key is sent
+	go func() {
+		_, _ = link.RPC(ctx, &amqp.Message{})
+	}()
+	require.Eventually(t, func() bool { return len(broker.Sent()) == 2 }, 5*time.Second, 10*time.Millisecond)
+	require.NotEqual(t, "key", broker.Sent()[1].Properties.MessageID)
+}
+
+func TestLateAndDuplicateResponsesForStableIDs(t *testing.T) {
+	recorder := &orphanRecorder{}
+	broker := newFakeBroker(nil)
+	link := newTestLink(t, broker, LinkWithOrphanHandler(recorder.handle))
+	ctx := context.Background()
+
+	// the first attempt gives up before its response arrives
+	attemptCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err := link.RPCWithIdempotencyKey(attemptCtx, "key", &amqp.Message{})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// the second attempt receives the response to the first, which is a response to the same request
+	result := make(chan error, 1)
+	go func() {
+		_, err := link.RPCWithIdempotencyKey(ctx, "key", &amqp.Message{})
+		result <- err
+	}()
+	require.Eventually(t, func() bool { return len(broker.Sent()) == 2 }, 5*time.Second, 10*time.Millisecond)
+
+	respondTo(broker, broker.Sent()[0])
+	require.NoError(t, <-result)
+
+	// the response to the second attempt is then a duplicate
+	respondTo(broker, broker.Sent()[1])
+	require.Eventually(t, func() bool { return len(recorder.get()) == 1 }, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, OrphanDuplicate, recorder.get()[0].Reason)
+	require.EqualValues(t, 1, link.Stats().OrphanedDuplicate)
+}
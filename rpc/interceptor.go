@@ -0,0 +1,88 @@
+package rpc
+
+//	MIT License
+//
+//	Copyright (c) Microsoft Corporation. All rights reserved.
+//
+//	Permission is hereby granted, free of charge, to any person obtaining a copy
+//	of this software and associated documentation files (the "Software"), to deal
+//	in the Software without restriction, including without limitation the rights
+//	to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//	copies of the Software, and to permit persons to whom the Software is
+//	furnished to do so, subject to the following conditions:
+//
+//	The above copyright notice and this permission notice shall be included in all
+//	copies or substantial portions of the Software.
+//
+//	THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//	AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//	LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//	OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//	SOFTWARE
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Azure/go-amqp"
+)
+
+type (
+	// Invoker sends a request over a Link and waits for its response
+	Invoker func(ctx context.Context, msg *amqp.Message) (*Response, error)
+
+	// Interceptor runs around every request made with Link.RPC. It may change msg before passing it to invoker, inspect
+	// or replace the response, or return an error without calling invoker at all. msg is a copy of the message given
+	// to RPC, so its properties and application properties can be changed freely.
+	Interceptor func(ctx context.Context, msg *amqp.Message, invoker Invoker) (*Response, error)
+)
+
+// LinkWithInterceptors installs interceptors around every request made with the Link. The first interceptor is the
+// outermost one: it sees the request first and the response last. Using the option more than once appends to the
+// chain.
+func LinkWithInterceptors(interceptors ...Interceptor) LinkOption {
+	return func(l *Link) error {
+		for _, interceptor := range interceptors {
+			if interceptor == nil {
+				return errors.New("interceptor must not be nil")
+			}
+		}
+		l.interceptors = append(l.interceptors, interceptors...)
+		return nil
+	}
+}
+
+// intercept calls the interceptors of the link around invoker
+func (l *Link) intercept(ctx context.Context, msg *amqp.Message, invoker Invoker) (*Response, error) {
+	if len(l.interceptors) == 0 {
+		return invoker(ctx, msg)
+	}
+
+	chain := invoker
+	for i := len(l.interceptors) - 1; i >= 0; i-- {
+		interceptor, next := l.interceptors[i], chain
+		chain = func(ctx context.Context, msg *amqp.Message) (*Response, error) {
+			return interceptor(ctx, msg, next)
+		}
+	}
+	return chain(ctx, copyMessage(msg))
+}
+
+// copyMessage makes a copy of msg which can be changed without affecting the original. Only the properties and
+// application properties are copied deeply; the copy always has application properties.
+func copyMessage(msg *amqp.Message) *amqp.Message {
+	copied := *msg
+
+	if msg.Properties != nil {
+		properties := *msg.Properties
+		copied.Properties = &properties
+	}
+
+	copied.ApplicationProperties = make(map[string]interface{}, len(msg.ApplicationProperties))
+	for k, v := range msg.ApplicationProperties {
+		copied.ApplicationProperties[k] = v
+	}
+	return &copied
+}
@@ -0,0 +1,77 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-amqp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInterceptorsRunInOrder(t *testing.T) {
+	var calls []string
+	record := func(name string) Interceptor {
+		return func(ctx context.Context, msg *amqp.Message, invoker Invoker) (*Response, error) {
+			calls = append(calls, name+" before")
+			res, err := invoker(ctx, msg)
+			calls = append(calls, name+" after")
+			return res, err
+		}
+	}
+
+	broker := okBroker()
+	link := newTestLink(t, broker, LinkWithInterceptors(record("outer")), LinkWithInterceptors(record("inner")))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := link.RPC(ctx, &amqp.Message{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"outer before", "inner before", "inner after", "outer after"}, calls)
+}
+
+func TestInterceptorsChangeRequestAndResponse(t *testing.T) {
+	tag := func(ctx context.Context, msg *amqp.Message, invoker Invoker) (*Response, error) {
+		msg.ApplicationProperties["tenant"] = "contoso"
+		res, err := invoker(ctx, msg)
+		if err != nil {
+			return nil, err
+		}
+		res.Description = "rewritten"
+		return res, nil
+	}
+
+	broker := okBroker()
+	link := newTestLink(t, broker, LinkWithInterceptors(tag))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	msg := &amqp.Message{}
+	res, err := link.RPC(ctx, msg)
+	require.NoError(t, err)
+	require.Equal(t, "rewritten", res.Description)
+	require.Equal(t, "contoso", broker.Sent()[0].ApplicationProperties["tenant"])
+	require.Nil(t, msg.ApplicationProperties, "the caller's message is left alone")
+}
+
+func TestInterceptorsShortCircuit(t *testing.T) {
+	denied := errors.New("denied")
+	deny := func(ctx context.Context, msg *amqp.Message, invoker Invoker) (*Response, error) {
+		return nil, denied
+	}
+
+	broker := okBroker()
+	link := newTestLink(t, broker, LinkWithInterceptors(deny))
+
+	_, err := link.RPC(context.Background(), &amqp.Message{})
+	require.ErrorIs(t, err, denied)
+	require.Empty(t, broker.Sent())
+}
+
+func TestLinkWithInterceptorsRejectsNil(t *testing.T) {
+	_, err := newLink("test-link", nil, "$management", LinkWithInterceptors(nil))
+	require.Error(t, err)
+}
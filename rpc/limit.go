@@ -0,0 +1,80 @@
+package rpc
+
+//	MIT License
+//
+//	Copyright (c) Microsoft Corporation. All rights reserved.
+//
+//	Permission is hereby granted, free of charge, to any person obtaining a copy
+//	of this software and associated documentation files (the "Software"), to deal
+//	in the Software without restriction, including without limitation the rights
+//	to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//	copies of the Software, and to permit persons to whom the Software is
+//	furnished to do so, subject to the following conditions:
+//
+//	The above copyright notice and this permission notice shall be included in all
+//	copies or substantial portions of the Software.
+//
+//	THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//	AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//	LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//	OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//	SOFTWARE
+
+import (
+	"context"
+	"errors"
+)
+
+// LinkWithMaxInFlight bounds how many requests a Link has outstanding at once. Requests beyond the limit wait for an
+// earlier request to complete, or for their context to be done. A limit of zero ties the limit to the credit of the
+// receiver, so the service can always send the response to every outstanding request.
+func LinkWithMaxInFlight(limit int) LinkOption {
+	return func(l *Link) error {
+		if limit < 0 {
+			return errors.New("max in-flight must not be negative")
+		}
+		l.maxInFlight = limit
+		l.limitInFlight = true
+		return nil
+	}
+}
+
+// acquire waits for the link to have room for another request. The returned function releases the room again.
+func (l *Link) acquire(ctx context.Context) (func(), error) {
+	l.responseMu.Lock()
+	l.stats.Queued++
+	l.responseMu.Unlock()
+
+	var err error
+	if l.inFlight != nil {
+		select {
+		case l.inFlight <- struct{}{}:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+
+	l.responseMu.Lock()
+	l.stats.Queued--
+	if err == nil {
+		l.stats.InFlight++
+	}
+	l.responseMu.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return func() {
+		if l.inFlight != nil {
+			<-l.inFlight
+		}
+
+		l.responseMu.Lock()
+		l.stats.InFlight--
+		l.checkDrainedLocked()
+		l.responseMu.Unlock()
+	}, nil
+}
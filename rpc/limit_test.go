@@ -0,0 +1,66 @@
+package rpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-amqp"
+	"github.com/stretchr/testify/require"
+)
+
+func respondTo(broker *fakeBroker, req *amqp.Message) {
+	res := statusResponse(200, "OK")
+	res.Properties = &amqp.MessageProperties{CorrelationID: req.Properties.MessageID}
+	broker.responses <- res
+}
+
+func TestLinkWithMaxInFlightQueuesRequests(t *testing.T) {
+	broker := newFakeBroker(nil)
+	link := newTestLink(t, broker, LinkWithMaxInFlight(1))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	first := make(chan error, 1)
+	go func() {
+		_, err := link.RPC(ctx, &amqp.Message{})
+		first <- err
+	}()
+	require.Eventually(t, func() bool { return len(broker.Sent()) == 1 }, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, 1, link.Stats().InFlight)
+
+	// a request which cannot get room before its context is done is never sent
+	shortCtx, shortCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer shortCancel()
+	_, err := link.RPC(shortCtx, &amqp.Message{})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Len(t, broker.Sent(), 1)
+
+	second := make(chan error, 1)
+	go func() {
+		_, err := link.RPC(ctx, &amqp.Message{})
+		second <- err
+	}()
+	require.Eventually(t, func() bool { return link.Stats().Queued == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	respondTo(broker, broker.Sent()[0])
+	require.NoError(t, <-first)
+
+	require.Eventually(t, func() bool { return len(broker.Sent()) == 2 }, 5*time.Second, 10*time.Millisecond)
+	respondTo(broker, broker.Sent()[1])
+	require.NoError(t, <-second)
+
+	stats := link.Stats()
+	require.Equal(t, 0, stats.InFlight)
+	require.Equal(t, 0, stats.Queued)
+}
+
+func TestLinkWithMaxInFlightDefaultsToCredit(t *testing.T) {
+	link, err := newLink("test-link", nil, "$management", LinkWithMaxInFlight(0))
+	require.NoError(t, err)
+	require.Equal(t, defaultReceiverCredits, cap(link.inFlight))
+
+	_, err = newLink("test-link", nil, "$management", LinkWithMaxInFlight(-1))
+	require.Error(t, err)
+}
@@ -0,0 +1,127 @@
+package rpc
+
+//	MIT License
+//
+//	Copyright (c) Microsoft Corporation. All rights reserved.
+//
+//	Permission is hereby granted, free of charge, to any person obtaining a copy
+//	of this software and associated documentation files (the "Software"), to deal
+//	in the Software without restriction, including without limitation the rights
+//	to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//	copies of the Software, and to permit persons to whom the Software is
+//	furnished to do so, subject to the following conditions:
+//
+//	The above copyright notice and this permission notice shall be included in all
+//	copies or substantial portions of the Software.
+//
+//	THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//	AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//	LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//	OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//	SOFTWARE
+
+import (
+	"errors"
+
+	"github.com/Azure/go-amqp"
+)
+
+// LinkWithReceiverCredit configures how many responses the receiver of a Link may be sent before it issues more
+// credit. The default is 1000.
+func LinkWithReceiverCredit(credit int32) LinkOption {
+	return func(l *Link) error {
+		if credit <= 0 {
+			return errors.New("receiver credit must be positive")
+		}
+		l.receiverCredit = credit
+		return nil
+	}
+}
+
+// LinkWithSettlementModes configures the settlement modes of the sender and receiver of a Link. Both links use, and
+// request of the broker, the same pair of modes. By default the modes are left to the broker.
+func LinkWithSettlementModes(senderMode amqp.SenderSettleMode, receiverMode amqp.ReceiverSettleMode) LinkOption {
+	return func(l *Link) error {
+		l.senderSettleMode = &senderMode
+		l.receiverSettleMode = &receiverMode
+		return nil
+	}
+}
+
+// LinkWithNamePrefix names the sender and receiver of a Link after prefix, followed by the ID of the Link, instead of
+// giving them random names
+func LinkWithNamePrefix(prefix string) LinkOption {
+	return func(l *Link) error {
+		if prefix == "" {
+			return errors.New("name prefix must not be empty")
+		}
+		l.namePrefix = prefix
+		return nil
+	}
+}
+
+// LinkWithLinkProperties configures the properties sent when the sender and receiver of a Link attach
+func LinkWithLinkProperties(properties map[string]interface{}) LinkOption {
+	return func(l *Link) error {
+		l.linkProperties = properties
+		return nil
+	}
+}
+
+// LinkWithCapabilities configures the capabilities the sender and receiver of a Link desire of the broker
+func LinkWithCapabilities(capabilities ...string) LinkOption {
+	return func(l *Link) error {
+		l.capabilities = append(l.capabilities, capabilities...)
+		return nil
+	}
+}
+
+// LinkWithMaxMessageSize configures the largest response the receiver of a Link accepts, in bytes. The default, zero,
+// means no limit.
+func LinkWithMaxMessageSize(size uint64) LinkOption {
+	return func(l *Link) error {
+		l.maxMessageSize = size
+		return nil
+	}
+}
+
+// senderOptions builds the options the sender of the link is attached with
+func (l *Link) senderOptions() *amqp.SenderOptions {
+	opts := &amqp.SenderOptions{
+		Capabilities:                l.capabilities,
+		Properties:                  l.linkProperties,
+		SettlementMode:              l.senderSettleMode,
+		RequestedReceiverSettleMode: l.receiverSettleMode,
+	}
+
+	if l.namePrefix != "" {
+		opts.Name = l.namePrefix + "-" + l.id + "-sender"
+	}
+	return opts
+}
+
+// receiverOptions builds the options the receiver of the link is attached with
+func (l *Link) receiverOptions() *amqp.ReceiverOptions {
+	opts := &amqp.ReceiverOptions{
+		Capabilities:              l.capabilities,
+		Credit:                    l.receiverCredit,
+		MaxMessageSize:            l.maxMessageSize,
+		Properties:                l.linkProperties,
+		SettlementMode:            l.receiverSettleMode,
+		RequestedSenderSettleMode: l.senderSettleMode,
+		TargetAddress:             l.clientAddress,
+	}
+
+	if l.namePrefix != "" {
+		opts.Name = l.namePrefix + "-" + l.id + "-receiver"
+	}
+
+	if l.sessionID != nil {
+		const name = "com.microsoft:session-filter"
+		const code = uint64(0x00000137000000C)
+		opts.Filters = append(opts.Filters, amqp.NewLinkFilter(name, code, l.sessionID))
+	}
+	return opts
+}
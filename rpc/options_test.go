@@ -0,0 +1,66 @@
+package rpc
+
+import (
+	"testing"
+
+	"github.com/Azure/go-amqp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLinkAttachDefaults(t *testing.T) {
+	link, err := newLink("test-link", nil, "$management")
+	require.NoError(t, err)
+
+	sender := link.senderOptions()
+	require.Empty(t, sender.Name)
+	require.Nil(t, sender.SettlementMode)
+
+	receiver := link.receiverOptions()
+	require.EqualValues(t, defaultReceiverCredits, receiver.Credit)
+	require.Equal(t, link.clientAddress, receiver.TargetAddress)
+	require.Empty(t, receiver.Filters)
+	require.Zero(t, receiver.MaxMessageSize)
+}
+
+func TestLinkAttachOptions(t *testing.T) {
+	sessionID := "session"
+	properties := map[string]interface{}{"com.microsoft:timeout": uint32(1000)}
+
+	link, err := newLink("test-link", nil, "$management",
+		LinkWithReceiverCredit(10),
+		LinkWithSettlementModes(amqp.SenderSettleModeSettled, amqp.ReceiverSettleModeFirst),
+		LinkWithNamePrefix("mgmt"),
+		LinkWithLinkProperties(properties),
+		LinkWithCapabilities("com.microsoft:session-filter"),
+		LinkWithMaxMessageSize(1<<20),
+		LinkWithSessionFilter(&sessionID),
+		LinkWithMaxInFlight(0),
+	)
+	require.NoError(t, err)
+
+	sender := link.senderOptions()
+	require.Equal(t, "mgmt-test-link-sender", sender.Name)
+	require.Equal(t, amqp.SenderSettleModeSettled, *sender.SettlementMode)
+	require.Equal(t, amqp.ReceiverSettleModeFirst, *sender.RequestedReceiverSettleMode)
+	require.Equal(t, properties, sender.Properties)
+	require.Equal(t, []string{"com.microsoft:session-filter"}, sender.Capabilities)
+
+	receiver := link.receiverOptions()
+	require.Equal(t, "mgmt-test-link-receiver", receiver.Name)
+	require.EqualValues(t, 10, receiver.Credit)
+	require.Equal(t, amqp.ReceiverSettleModeFirst, *receiver.SettlementMode)
+	require.Equal(t, amqp.SenderSettleModeSettled, *receiver.RequestedSenderSettleMode)
+	require.EqualValues(t, 1<<20, receiver.MaxMessageSize)
+	require.Len(t, receiver.Filters, 1)
+
+	// the in-flight limit follows the configured credit
+	require.Equal(t, 10, cap(link.inFlight))
+}
+
+func TestLinkAttachOptionsValidate(t *testing.T) {
+	_, err := newLink("test-link", nil, "$management", LinkWithReceiverCredit(0))
+	require.Error(t, err)
+
+	_, err = newLink("test-link", nil, "$management", LinkWithNamePrefix(""))
+	require.Error(t, err)
+}
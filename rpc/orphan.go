@@ -0,0 +1,194 @@
+package rpc
+
+//	MIT License
+//
+//	Copyright (c) Microsoft Corporation. All rights reserved.
+//
+//	Permission is hereby granted, free of charge, to any person obtaining a copy
+//	of this software and associated documentation files (the "Software"), to deal
+//	in the Software without restriction, including without limitation the rights
+//	to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//	copies of the Software, and to permit persons to whom the Software is
+//	furnished to do so, subject to the following conditions:
+//
+//	The above copyright notice and this permission notice shall be included in all
+//	copies or substantial portions of the Software.
+//
+//	THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//	AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//	LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//	OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//	SOFTWARE
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/devigned/tab"
+
+	"github.com/Azure/go-amqp"
+)
+
+// maxRecentIDs bounds how many message IDs of requests which were answered, or whose callers gave up, are remembered,
+// so that another response arriving for one of them can be reported as a duplicate or as late
+const maxRecentIDs = 1024
+
+const (
+	// OrphanUnknownID is the reason for a response correlated with a message ID no request is waiting for
+	OrphanUnknownID OrphanReason = iota + 1
+	// OrphanWrongType is the reason for a response whose correlation ID is missing or is not a string
+	OrphanWrongType
+	// OrphanLate is the reason for a response which arrived after the context of its request was done
+	OrphanLate
+	// OrphanDuplicate is the reason for a response to a request which has already been answered, which happens when
+	// a request retried with the same message ID is answered more than once
+	OrphanDuplicate
+)
+
+type (
+	// OrphanReason describes why a response could not be delivered to a request
+	OrphanReason int
+
+	// OrphanedResponse is a response received by a Link which could not be delivered to a request
+	OrphanedResponse struct {
+		// Reason is why the response could not be delivered
+		Reason OrphanReason
+		// CorrelationID is the correlation ID of the response, if it has one
+		CorrelationID interface{}
+		// Message is the response itself
+		Message *amqp.Message
+	}
+
+	// OrphanHandler is called for each orphaned response. It is called from the goroutine which receives responses,
+	// so it must not block.
+	OrphanHandler func(orphan OrphanedResponse)
+
+	// LinkStats are counters describing the traffic of a Link
+	LinkStats struct {
+		// OrphanedUnknownID is the number of responses received for message IDs no request was waiting for
+		OrphanedUnknownID uint64
+		// OrphanedWrongType is the number of responses received without a string correlation ID
+		OrphanedWrongType uint64
+		// OrphanedLate is the number of responses received after their request gave up
+		OrphanedLate uint64
+		// OrphanedDuplicate is the number of responses received for requests which had already been answered
+		OrphanedDuplicate uint64
+		// InFlight is the number of requests currently sent, or being sent, and waiting for their response
+		InFlight int
+		// Queued is the number of requests currently waiting for room under the limit set by LinkWithMaxInFlight
+		Queued int
+		// ReceiveErrors is the number of transient errors receiving responses
+		ReceiveErrors uint64
+	}
+)
+
+// LinkWithOrphanHandler registers a handler for responses which cannot be delivered to a request, because their
+// correlation ID is not recognized or because the caller stopped waiting before they arrived
+func LinkWithOrphanHandler(handler OrphanHandler) LinkOption {
+	return func(l *Link) error {
+		if handler == nil {
+			return errors.New("orphan handler must not be nil")
+		}
+		l.orphanHandler = handler
+		return nil
+	}
+}
+
+// String returns the name of the reason
+func (r OrphanReason) String() string {
+	switch r {
+	case OrphanUnknownID:
+		return "unknown-id"
+	case OrphanWrongType:
+		return "wrong-type"
+	case OrphanLate:
+		return "late"
+	case OrphanDuplicate:
+		return "duplicate"
+	default:
+		return "unknown"
+	}
+}
+
+// Orphaned returns the total number of orphaned responses
+func (s LinkStats) Orphaned() uint64 {
+	return s.OrphanedUnknownID + s.OrphanedWrongType + s.OrphanedLate + s.OrphanedDuplicate
+}
+
+// Stats returns a snapshot of the counters of the link
+func (l *Link) Stats() LinkStats {
+	l.responseMu.Lock()
+	defer l.responseMu.Unlock()
+
+	return l.stats
+}
+
+// orphan settles an orphaned response with accept, so it does not keep holding link credit, counts it and passes it
+// to the orphan handler, if there is one
+func (l *Link) orphan(accept acceptFunc, res *amqp.Message, reason OrphanReason) {
+	if accept != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := accept(ctx, res); err != nil {
+			tab.For(ctx).Error(err)
+		}
+		cancel()
+	}
+
+	orphan := OrphanedResponse{
+		Reason:  reason,
+		Message: res,
+	}
+	if res.Properties != nil {
+		orphan.CorrelationID = res.Properties.CorrelationID
+	}
+
+	l.responseMu.Lock()
+	switch reason {
+	case OrphanUnknownID:
+		l.stats.OrphanedUnknownID++
+	case OrphanWrongType:
+		l.stats.OrphanedWrongType++
+	case OrphanLate:
+		l.stats.OrphanedLate++
+	case OrphanDuplicate:
+		l.stats.OrphanedDuplicate++
+	}
+	l.responseMu.Unlock()
+
+	if l.orphanHandler != nil {
+		l.orphanHandler(orphan)
+	}
+}
+
+// remember records the reason to give for another response to the request with messageID
+func (l *Link) remember(messageID string, reason OrphanReason) {
+	l.responseMu.Lock()
+	defer l.responseMu.Unlock()
+
+	if l.recent == nil {
+		l.recent = map[string]OrphanReason{}
+	}
+
+	if _, ok := l.recent[messageID]; !ok {
+		if len(l.recentOrder) >= maxRecentIDs {
+			delete(l.recent, l.recentOrder[0])
+			l.recentOrder = l.recentOrder[1:]
+		}
+		l.recentOrder = append(l.recentOrder, messageID)
+	}
+	l.recent[messageID] = reason
+}
+
+// orphanReason returns why no request was waiting for a response correlated with messageID
+func (l *Link) orphanReason(messageID string) OrphanReason {
+	l.responseMu.Lock()
+	defer l.responseMu.Unlock()
+
+	if reason, ok := l.recent[messageID]; ok {
+		return reason
+	}
+	return OrphanUnknownID
+}
@@ -0,0 +1,130 @@
+package rpc
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-amqp"
+	"github.com/stretchr/testify/require"
+)
+
+type orphanRecorder struct {
+	mu      sync.Mutex
+	orphans []OrphanedResponse
+}
+
+func (r *orphanRecorder) handle(orphan OrphanedResponse) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.orphans = append(r.orphans, orphan)
+}
+
+func (r *orphanRecorder) get() []OrphanedResponse {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]OrphanedResponse(nil), r.orphans...)
+}
+
+func TestResponseRouterReportsOrphans(t *testing.T) {
+	recorder := &orphanRecorder{}
+	receiver := &fakeReceiver{
+		Responses: []rpcResponse{
+			{amqpMessageWithCorrelationId("nobody is waiting"), nil},
+			{&amqp.Message{Properties: &amqp.MessageProperties{CorrelationID: uint64(1)}}, nil},
+			{&amqp.Message{}, nil},
+			{nil, &amqp.LinkError{}},
+		},
+	}
+
+	link := &Link{
+		responseMap:   map[string]chan rpcResponse{},
+		receiver:      receiver,
+		orphanHandler: recorder.handle,
+	}
+
+	link.startResponseRouter()
+
+	orphans := recorder.get()
+	require.Len(t, orphans, 3)
+	require.Equal(t, OrphanUnknownID, orphans[0].Reason)
+	require.Equal(t, "nobody is waiting", orphans[0].CorrelationID)
+	require.Equal(t, OrphanWrongType, orphans[1].Reason)
+	require.Equal(t, uint64(1), orphans[1].CorrelationID)
+	require.Equal(t, OrphanWrongType, orphans[2].Reason)
+	require.Nil(t, orphans[2].CorrelationID)
+
+	stats := link.Stats()
+	require.EqualValues(t, 1, stats.OrphanedUnknownID)
+	require.EqualValues(t, 2, stats.OrphanedWrongType)
+	require.EqualValues(t, 3, stats.Orphaned())
+}
+
+func TestLinkReportsLateResponses(t *testing.T) {
+	recorder := &orphanRecorder{}
+	broker := newFakeBroker(nil)
+	link := newTestLink(t, broker, LinkWithOrphanHandler(recorder.handle))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := link.RPC(ctx, &amqp.Message{})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	sent := broker.Sent()
+	require.Len(t, sent, 1)
+
+	late := statusResponse(200, "OK")
+	late.Properties = &amqp.MessageProperties{CorrelationID: sent[0].Properties.MessageID}
+	broker.responses <- late
+
+	require.Eventually(t, func() bool { return len(recorder.get()) == 1 }, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, OrphanLate, recorder.get()[0].Reason)
+	require.Same(t, late, recorder.get()[0].Message)
+	require.EqualValues(t, 1, link.Stats().OrphanedLate)
+}
+
+func TestLinkSettlesOrphans(t *testing.T) {
+	broker := newFakeBroker(nil)
+	link := newTestLink(t, broker)
+
+	var mu sync.Mutex
+	var accepted []*amqp.Message
+	link.messageAccept = func(ctx context.Context, message *amqp.Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		accepted = append(accepted, message)
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := link.RPC(ctx, &amqp.Message{})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	late := statusResponse(200, "OK")
+	late.Properties = &amqp.MessageProperties{CorrelationID: broker.Sent()[0].Properties.MessageID}
+	unknown := amqpMessageWithCorrelationId("nobody is waiting")
+	broker.responses <- late
+	broker.responses <- unknown
+
+	require.Eventually(t, func() bool { return link.Stats().Orphaned() == 2 }, 5*time.Second, 10*time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, []*amqp.Message{late, unknown}, accepted)
+}
+
+func TestLinkWithOrphanHandlerRejectsNil(t *testing.T) {
+	_, err := newLink("test-link", nil, "$management", LinkWithOrphanHandler(nil))
+	require.Error(t, err)
+}
+
+func TestOrphanReasonString(t *testing.T) {
+	require.Equal(t, "unknown-id", OrphanUnknownID.String())
+	require.Equal(t, "wrong-type", OrphanWrongType.String())
+	require.Equal(t, "late", OrphanLate.String())
+	require.Equal(t, "duplicate", OrphanDuplicate.String())
+}
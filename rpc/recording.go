@@ -0,0 +1,529 @@
+package rpc
+
+//	MIT License
+//
+//	Copyright (c) Microsoft Corporation. All rights reserved.
+//
+//	Permission is hereby granted, free of charge, to any person obtaining a copy
+//	of this software and associated documentation files (the "Software"), to deal
+//	in the Software without restriction, including without limitation the rights
+//	to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//	copies of the Software, and to permit persons to whom the Software is
+//	furnished to do so, subject to the following conditions:
+//
+//	The above copyright notice and this permission notice shall be included in all
+//	copies or substantial portions of the Software.
+//
+//	THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//	AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//	LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//	OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//	SOFTWARE
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Azure/go-amqp"
+)
+
+type (
+	// Recording is a sequence of request/response exchanges captured by a Recorder. It is saved as JSON in which
+	// every AMQP value carries its type, so that replaying it delivers the same types the service sent.
+	Recording struct {
+		Exchanges []Exchange `json:"exchanges"`
+	}
+
+	// Exchange is a request and the response the service sent to it. Response is nil if none arrived.
+	Exchange struct {
+		Request  *RecordedMessage `json:"request"`
+		Response *RecordedMessage `json:"response,omitempty"`
+	}
+
+	// RecordedMessage is the part of an AMQP message which is relevant to request/response exchanges
+	RecordedMessage struct {
+		MessageID             *TypedValue           `json:"messageId,omitempty"`
+		CorrelationID         *TypedValue           `json:"correlationId,omitempty"`
+		To                    string                `json:"to,omitempty"`
+		ReplyTo               string                `json:"replyTo,omitempty"`
+		Subject               string                `json:"subject,omitempty"`
+		ApplicationProperties map[string]TypedValue `json:"applicationProperties,omitempty"`
+		Value                 *TypedValue           `json:"value,omitempty"`
+		Data                  [][]byte              `json:"data,omitempty"`
+	}
+
+	// TypedValue is an AMQP value annotated with its type, such as {"type": "int", "value": 404}. Maps are encoded as
+	// lists of key/value entries, since AMQP map keys need not be strings.
+	TypedValue struct {
+		Type  string          `json:"type"`
+		Value json.RawMessage `json:"value,omitempty"`
+	}
+
+	typedEntry struct {
+		Key   TypedValue `json:"key"`
+		Value TypedValue `json:"value"`
+	}
+
+	// typedArray is an AMQP array, whose items all have the same type
+	typedArray struct {
+		Type  string            `json:"type"`
+		Items []json.RawMessage `json:"items"`
+	}
+)
+
+// symbolType is the type go-amqp encodes as an AMQP symbol. go-amqp does not export it, so it is taken from a decoded
+// array of symbols.
+var symbolType = func() reflect.Type {
+	// an amqp-value section holding an array of the single symbol "x"
+	var msg amqp.Message
+	if err := msg.UnmarshalBinary([]byte{0x00, 0x53, 0x77, 0xe0, 0x04, 0x01, 0xa3, 0x01, 'x'}); err == nil {
+		if t := reflect.TypeOf(msg.Value); t != nil && t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.String {
+			return t.Elem()
+		}
+	}
+	return reflect.TypeOf("")
+}()
+
+// arrayItemTypes are the Go types arrays are replayed with, by the recorded type of their items
+var arrayItemTypes = map[string]reflect.Type{
+	"boolean":   reflect.TypeOf(false),
+	"string":    reflect.TypeOf(""),
+	"symbol":    symbolType,
+	"byte":      reflect.TypeOf(int8(0)),
+	"short":     reflect.TypeOf(int16(0)),
+	"int":       reflect.TypeOf(int32(0)),
+	"long":      reflect.TypeOf(int64(0)),
+	"ushort":    reflect.TypeOf(uint16(0)),
+	"uint":      reflect.TypeOf(uint32(0)),
+	"ulong":     reflect.TypeOf(uint64(0)),
+	"float":     reflect.TypeOf(float32(0)),
+	"double":    reflect.TypeOf(float64(0)),
+	"binary":    reflect.TypeOf([]byte(nil)),
+	"timestamp": reflect.TypeOf(time.Time{}),
+	"uuid":      reflect.TypeOf(amqp.UUID{}),
+}
+
+// LoadRecording reads a Recording saved with Recording.Save
+func LoadRecording(r io.Reader) (*Recording, error) {
+	var recording Recording
+	if err := json.NewDecoder(r).Decode(&recording); err != nil {
+		return nil, err
+	}
+	return &recording, nil
+}
+
+// Save writes the recording to w as indented JSON, suitable for a golden file
+func (r *Recording) Save(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// NewRecordedMessage captures msg
+func NewRecordedMessage(msg *amqp.Message) (*RecordedMessage, error) {
+	recorded := &RecordedMessage{
+		Data: msg.Data,
+	}
+
+	if msg.Properties != nil {
+		var err error
+		if recorded.MessageID, err = optionalTypedValue(msg.Properties.MessageID); err != nil {
+			return nil, fmt.Errorf("message ID: %w", err)
+		}
+		if recorded.CorrelationID, err = optionalTypedValue(msg.Properties.CorrelationID); err != nil {
+			return nil, fmt.Errorf("correlation ID: %w", err)
+		}
+		recorded.To = deref(msg.Properties.To)
+		recorded.ReplyTo = deref(msg.Properties.ReplyTo)
+		recorded.Subject = deref(msg.Properties.Subject)
+	}
+
+	if len(msg.ApplicationProperties) > 0 {
+		recorded.ApplicationProperties = make(map[string]TypedValue, len(msg.ApplicationProperties))
+		for k, v := range msg.ApplicationProperties {
+			typed, err := NewTypedValue(v)
+			if err != nil {
+				return nil, fmt.Errorf("application property %s: %w", k, err)
+			}
+			recorded.ApplicationProperties[k] = typed
+		}
+	}
+
+	value, err := optionalTypedValue(msg.Value)
+	if err != nil {
+		return nil, fmt.Errorf("value: %w", err)
+	}
+	recorded.Value = value
+	return recorded, nil
+}
+
+// Message rebuilds the recorded message
+func (m *RecordedMessage) Message() (*amqp.Message, error) {
+	msg := &amqp.Message{
+		Properties: &amqp.MessageProperties{},
+		Data:       m.Data,
+	}
+
+	var err error
+	if m.MessageID != nil {
+		if msg.Properties.MessageID, err = m.MessageID.Interface(); err != nil {
+			return nil, err
+		}
+	}
+	if m.CorrelationID != nil {
+		if msg.Properties.CorrelationID, err = m.CorrelationID.Interface(); err != nil {
+			return nil, err
+		}
+	}
+	msg.Properties.To = ref(m.To)
+	msg.Properties.ReplyTo = ref(m.ReplyTo)
+	msg.Properties.Subject = ref(m.Subject)
+
+	if m.ApplicationProperties != nil {
+		msg.ApplicationProperties = make(map[string]interface{}, len(m.ApplicationProperties))
+		for k, typed := range m.ApplicationProperties {
+			if msg.ApplicationProperties[k], err = typed.Interface(); err != nil {
+				return nil, fmt.Errorf("application property %s: %w", k, err)
+			}
+		}
+	}
+
+	if m.Value != nil {
+		if msg.Value, err = m.Value.Interface(); err != nil {
+			return nil, fmt.Errorf("value: %w", err)
+		}
+	}
+	return msg, nil
+}
+
+// NewTypedValue annotates v with its AMQP type. Symbols, including the keys of maps and the items of arrays, are
+// recorded with the symbol type and replayed as symbols, so a replayed message encodes like the recorded one.
+func NewTypedValue(v interface{}) (TypedValue, error) {
+	typed := func(t string, value interface{}) (TypedValue, error) {
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return TypedValue{}, err
+		}
+		return TypedValue{Type: t, Value: raw}, nil
+	}
+
+	switch v := v.(type) {
+	case nil:
+		return TypedValue{Type: "null"}, nil
+	case bool:
+		return typed("boolean", v)
+	case string:
+		return typed("string", v)
+	case int8:
+		return typed("byte", v)
+	case int16:
+		return typed("short", v)
+	case int32:
+		return typed("int", v)
+	case int64:
+		return typed("long", v)
+	case int:
+		return typed("long", v)
+	case uint8:
+		return typed("ubyte", v)
+	case uint16:
+		return typed("ushort", v)
+	case uint32:
+		return typed("uint", v)
+	case uint64:
+		return typed("ulong", v)
+	case uint:
+		return typed("ulong", v)
+	case float32:
+		return typed("float", v)
+	case float64:
+		return typed("double", v)
+	case []byte:
+		return typed("binary", base64.StdEncoding.EncodeToString(v))
+	case time.Time:
+		return typed("timestamp", v.UTC().Format(time.RFC3339Nano))
+	case amqp.UUID:
+		return typed("uuid", v.String())
+	case []interface{}:
+		list := make([]TypedValue, len(v))
+		for i, item := range v {
+			var err error
+			if list[i], err = NewTypedValue(item); err != nil {
+				return TypedValue{}, err
+			}
+		}
+		return typed("list", list)
+	case map[string]interface{}:
+		entries := make([]typedEntry, 0, len(v))
+		for k, item := range v {
+			key, err := typed("string", k)
+			if err != nil {
+				return TypedValue{}, err
+			}
+			value, err := NewTypedValue(item)
+			if err != nil {
+				return TypedValue{}, err
+			}
+			entries = append(entries, typedEntry{Key: key, Value: value})
+		}
+		sortEntries(entries)
+		return typed("map", entries)
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.String:
+		// symbols decode to a named string type
+		return typed("symbol", rv.String())
+	case reflect.Slice:
+		itemType, err := NewTypedValue(reflect.Zero(rv.Type().Elem()).Interface())
+		if err != nil {
+			return TypedValue{}, err
+		}
+		array := typedArray{Type: itemType.Type, Items: make([]json.RawMessage, rv.Len())}
+		for i := range array.Items {
+			item, err := NewTypedValue(rv.Index(i).Interface())
+			if err != nil {
+				return TypedValue{}, err
+			}
+			array.Items[i] = item.Value
+		}
+		return typed("array", array)
+	case reflect.Map:
+		entries := make([]typedEntry, 0, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			key, err := NewTypedValue(iter.Key().Interface())
+			if err != nil {
+				return TypedValue{}, err
+			}
+			value, err := NewTypedValue(iter.Value().Interface())
+			if err != nil {
+				return TypedValue{}, err
+			}
+			entries = append(entries, typedEntry{Key: key, Value: value})
+		}
+		sortEntries(entries)
+		return typed("map", entries)
+	default:
+		return TypedValue{}, fmt.Errorf("cannot record a value of type %T", v)
+	}
+}
+
+// Interface returns the value with its recorded type
+func (t TypedValue) Interface() (interface{}, error) {
+	switch t.Type {
+	case "null":
+		return nil, nil
+	case "boolean":
+		return unmarshalTyped[bool](t.Value)
+	case "string":
+		return unmarshalTyped[string](t.Value)
+	case "symbol":
+		s, err := unmarshalTyped[string](t.Value)
+		if err != nil {
+			return nil, err
+		}
+		return reflect.ValueOf(s).Convert(symbolType).Interface(), nil
+	case "byte":
+		return unmarshalTyped[int8](t.Value)
+	case "short":
+		return unmarshalTyped[int16](t.Value)
+	case "int":
+		return unmarshalTyped[int32](t.Value)
+	case "long":
+		return unmarshalTyped[int64](t.Value)
+	case "ubyte":
+		return unmarshalTyped[uint8](t.Value)
+	case "ushort":
+		return unmarshalTyped[uint16](t.Value)
+	case "uint":
+		return unmarshalTyped[uint32](t.Value)
+	case "ulong":
+		return unmarshalTyped[uint64](t.Value)
+	case "float":
+		return unmarshalTyped[float32](t.Value)
+	case "double":
+		return unmarshalTyped[float64](t.Value)
+	case "binary":
+		s, err := unmarshalTyped[string](t.Value)
+		if err != nil {
+			return nil, err
+		}
+		return base64.StdEncoding.DecodeString(s)
+	case "timestamp":
+		s, err := unmarshalTyped[string](t.Value)
+		if err != nil {
+			return nil, err
+		}
+		return time.Parse(time.RFC3339Nano, s)
+	case "uuid":
+		s, err := unmarshalTyped[string](t.Value)
+		if err != nil {
+			return nil, err
+		}
+		return parseUUID(s)
+	case "list":
+		items, err := unmarshalTyped[[]TypedValue](t.Value)
+		if err != nil {
+			return nil, err
+		}
+		list := make([]interface{}, len(items))
+		for i, item := range items {
+			if list[i], err = item.Interface(); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+	case "array":
+		array, err := unmarshalTyped[typedArray](t.Value)
+		if err != nil {
+			return nil, err
+		}
+		itemType, ok := arrayItemTypes[array.Type]
+		if !ok {
+			return nil, fmt.Errorf("cannot replay an array of %s", array.Type)
+		}
+		items := reflect.MakeSlice(reflect.SliceOf(itemType), len(array.Items), len(array.Items))
+		for i, raw := range array.Items {
+			item, err := TypedValue{Type: array.Type, Value: raw}.Interface()
+			if err != nil {
+				return nil, err
+			}
+			items.Index(i).Set(reflect.ValueOf(item).Convert(itemType))
+		}
+		return items.Interface(), nil
+	case "map":
+		entries, err := unmarshalTyped[[]typedEntry](t.Value)
+		if err != nil {
+			return nil, err
+		}
+		return entriesToMap(entries)
+	default:
+		return nil, fmt.Errorf("unknown recorded type %q", t.Type)
+	}
+}
+
+func optionalTypedValue(v interface{}) (*TypedValue, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	typed, err := NewTypedValue(v)
+	if err != nil {
+		return nil, err
+	}
+	return &typed, nil
+}
+
+func unmarshalTyped[T any](raw json.RawMessage) (T, error) {
+	var v T
+	err := json.Unmarshal(raw, &v)
+	return v, err
+}
+
+// entriesToMap rebuilds a map. Maps whose keys are all strings, such as management request and response bodies,
+// become map[string]interface{}, and maps whose keys are all symbols are keyed by symbols.
+func entriesToMap(entries []typedEntry) (interface{}, error) {
+	stringKeys, symbolKeys := true, len(entries) > 0
+	for _, entry := range entries {
+		stringKeys = stringKeys && entry.Key.Type == "string"
+		symbolKeys = symbolKeys && entry.Key.Type == "symbol"
+	}
+
+	if symbolKeys {
+		m := reflect.MakeMapWithSize(reflect.MapOf(symbolType, reflect.TypeOf((*interface{})(nil)).Elem()), len(entries))
+		for _, entry := range entries {
+			key, err := entry.Key.Interface()
+			if err != nil {
+				return nil, err
+			}
+			value, err := entry.Value.Interface()
+			if err != nil {
+				return nil, err
+			}
+			valueOf := reflect.New(m.Type().Elem()).Elem()
+			if value != nil {
+				valueOf.Set(reflect.ValueOf(value))
+			}
+			m.SetMapIndex(reflect.ValueOf(key), valueOf)
+		}
+		return m.Interface(), nil
+	}
+
+	if stringKeys {
+		m := make(map[string]interface{}, len(entries))
+		for _, entry := range entries {
+			key, err := unmarshalTyped[string](entry.Key.Value)
+			if err != nil {
+				return nil, err
+			}
+			if m[key], err = entry.Value.Interface(); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	}
+
+	m := make(map[interface{}]interface{}, len(entries))
+	for _, entry := range entries {
+		key, err := entry.Key.Interface()
+		if err != nil {
+			return nil, err
+		}
+		if reflect.TypeOf(key) != nil && !reflect.TypeOf(key).Comparable() {
+			return nil, fmt.Errorf("map key of type %s cannot be replayed", entry.Key.Type)
+		}
+		if m[key], err = entry.Value.Interface(); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// sortEntries orders map entries by key, so recordings of the same map are identical
+func sortEntries(entries []typedEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Key.Type != entries[j].Key.Type {
+			return entries[i].Key.Type < entries[j].Key.Type
+		}
+		return string(entries[i].Key.Value) < string(entries[j].Key.Value)
+	})
+}
+
+func parseUUID(s string) (amqp.UUID, error) {
+	var id amqp.UUID
+	raw, err := hex.DecodeString(strings.ReplaceAll(s, "-", ""))
+	if err != nil {
+		return id, err
+	}
+	if len(raw) != len(id) {
+		return id, errors.New("malformed UUID")
+	}
+	copy(id[:], raw)
+	return id, nil
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func ref(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
@@ -0,0 +1,155 @@
+package rpc
+
+//	MIT License
+//
+//	Copyright (c) Microsoft Corporation. All rights reserved.
+//
+//	Permission is hereby granted, free of charge, to any person obtaining a copy
+//	of this software and associated documentation files (the "Software"), to deal
+//	in the Software without restriction, including without limitation the rights
+//	to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//	copies of the Software, and to permit persons to whom the Software is
+//	furnished to do so, subject to the following conditions:
+//
+//	The above copyright notice and this permission notice shall be included in all
+//	copies or substantial portions of the Software.
+//
+//	THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//	AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//	LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//	OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//	SOFTWARE
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/devigned/tab"
+
+	"github.com/Azure/azure-amqp-common-go/v4/internal/tracing"
+	"github.com/Azure/go-amqp"
+)
+
+// maxRecoveries bounds how many times a single request recovers the link
+const maxRecoveries = 3
+
+// ErrLinkClosed is returned by requests made on a Link after it has been closed
+var ErrLinkClosed = errors.New("rpc: link is closed")
+
+// LinkWithRecovery configures a Link to recover after the service detaches it. The next request made after a detach
+// re-creates the sender and receiver of the link, and the session as well if it is gone and the Link was built with
+// NewLink. Requests which were waiting for a response when the link detached fail, unless LinkWithResendOnRecovery is
+// also used.
+func LinkWithRecovery() LinkOption {
+	return func(l *Link) error {
+		l.recovery = true
+		return nil
+	}
+}
+
+// LinkWithResendOnRecovery configures a recovering Link to send requests which were waiting for a response when the
+// link detached again once it has recovered. Only use it if the operations sent over the link are safe to repeat,
+// since the service may have processed a request before it detached. Implies LinkWithRecovery.
+func LinkWithResendOnRecovery() LinkOption {
+	return func(l *Link) error {
+		l.recovery = true
+		l.resendInFlight = true
+		return nil
+	}
+}
+
+// Generation returns how many times the link has recovered. It starts at zero and increases by one each time the
+// sender and receiver are re-created.
+func (l *Link) Generation() uint64 {
+	l.responseMu.Lock()
+	defer l.responseMu.Unlock()
+
+	return l.generation
+}
+
+// recover replaces the sender and receiver of the link if it is still on the generation which failed. Requests still
+// waiting on that generation are failed, and a new response router is started with the next request.
+func (l *Link) recover(ctx context.Context, failed uint64) error {
+	ctx, span := tracing.StartSpanFromContext(ctx, "az-amqp-common.rpc.recover")
+	defer span.End()
+
+	l.recoverMu.Lock()
+	defer l.recoverMu.Unlock()
+
+	l.responseMu.Lock()
+	closed, generation := l.closed, l.generation
+	oldSender, oldReceiver := l.sender, l.receiver
+	l.responseMu.Unlock()
+
+	if closed {
+		return ErrLinkClosed
+	}
+
+	if generation != failed {
+		// another request already recovered the link
+		return nil
+	}
+
+	closeCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	if oldReceiver != nil {
+		_ = oldReceiver.Close(closeCtx)
+	}
+	if oldSender != nil {
+		_ = oldSender.Close(closeCtx)
+	}
+	cancel()
+
+	if l.reattach == nil {
+		return errors.New("rpc: link cannot be recovered")
+	}
+
+	sender, receiver, accept, err := l.reattach(ctx)
+	if err != nil {
+		return err
+	}
+
+	l.responseMu.Lock()
+	stale := l.responseMap
+	l.sender, l.receiver, l.messageAccept = sender, receiver, accept
+	l.responseMap = map[string]chan rpcResponse{}
+	l.startResponseRouterOnce = &sync.Once{}
+	l.generation++
+	l.responseMu.Unlock()
+
+	// the router of the failed generation is gone, so nobody else is going to answer these
+	for _, ch := range stale {
+		ch <- rpcResponse{err: &amqp.LinkError{}}
+	}
+
+	tab.For(ctx).Info("rpc link recovered")
+	return nil
+}
+
+// reattachSession attaches a new sender and receiver on the session of the link. If that fails and the link owns its
+// session, the session is re-created first.
+func (l *Link) reattachSession(ctx context.Context) (amqpSender, amqpReceiver, acceptFunc, error) {
+	sender, receiver, err := l.attach(ctx, l.session)
+	if err != nil && l.conn != nil {
+		session, sessionErr := l.conn.NewSession(ctx, nil)
+		if sessionErr != nil {
+			return nil, nil, nil, sessionErr
+		}
+
+		closeCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		_ = l.session.Close(closeCtx)
+		cancel()
+
+		l.session = session
+		sender, receiver, err = l.attach(ctx, session)
+	}
+
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	recordingSender, recordingReceiver := l.record(sender, receiver)
+	return recordingSender, recordingReceiver, receiver.AcceptMessage, nil
+}
@@ -0,0 +1,166 @@
+package rpc
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-amqp"
+	"github.com/stretchr/testify/require"
+)
+
+// detachingBroker is a fakeBroker which detaches as soon as it receives a request, without responding
+func detachingBroker() *fakeBroker {
+	var broker *fakeBroker
+	broker = newFakeBroker(func(req *amqp.Message) *amqp.Message {
+		_ = broker.Close(context.Background())
+		return nil
+	})
+	return broker
+}
+
+func withReattach(link *Link, brokers ...*fakeBroker) *int32 {
+	var calls int32
+	link.reattach = func(ctx context.Context) (amqpSender, amqpReceiver, acceptFunc, error) {
+		broker := brokers[atomic.AddInt32(&calls, 1)-1]
+		return broker, broker, func(ctx context.Context, message *amqp.Message) error { return nil }, nil
+	}
+	return &calls
+}
+
+func okBroker() *fakeBroker {
+	return newFakeBroker(func(req *amqp.Message) *amqp.Message {
+		return statusResponse(200, "OK")
+	})
+}
+
+func TestLinkRecoversAfterDetach(t *testing.T) {
+	first := okBroker()
+	link := newTestLink(t, first, LinkWithRecovery())
+	second := okBroker()
+	calls := withReattach(link, second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := link.RPC(ctx, &amqp.Message{})
+	require.NoError(t, err)
+	require.EqualValues(t, 0, link.Generation())
+
+	// the service detaches the link while it is idle
+	require.NoError(t, first.Close(ctx))
+	require.Eventually(t, func() bool { return link.addChannelToMap("probe") == nil }, 5*time.Second, 10*time.Millisecond)
+
+	res, err := link.RPC(ctx, &amqp.Message{})
+	require.NoError(t, err)
+	require.Equal(t, 200, res.Code)
+	require.EqualValues(t, 1, link.Generation())
+	require.EqualValues(t, 1, atomic.LoadInt32(calls))
+	require.Len(t, second.Sent(), 1)
+}
+
+func TestLinkWithoutRecoveryStaysDetached(t *testing.T) {
+	first := okBroker()
+	link := newTestLink(t, first)
+	calls := withReattach(link, okBroker())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := link.RPC(ctx, &amqp.Message{})
+	require.NoError(t, err)
+
+	require.NoError(t, first.Close(ctx))
+	require.Eventually(t, func() bool { return link.addChannelToMap("probe") == nil }, 5*time.Second, 10*time.Millisecond)
+
+	_, err = link.RPC(ctx, &amqp.Message{})
+	var linkErr *amqp.LinkError
+	require.ErrorAs(t, err, &linkErr)
+	require.EqualValues(t, 0, atomic.LoadInt32(calls))
+	require.EqualValues(t, 0, link.Generation())
+}
+
+func TestLinkFailsInFlightRequestsWithoutResend(t *testing.T) {
+	link := newTestLink(t, detachingBroker(), LinkWithRecovery())
+	second := okBroker()
+	withReattach(link, second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := link.RPC(ctx, &amqp.Message{})
+	var linkErr *amqp.LinkError
+	require.ErrorAs(t, err, &linkErr)
+	require.Empty(t, second.Sent(), "a request which may have been processed must not be sent again")
+
+	// the next request recovers the link
+	_, err = link.RPC(ctx, &amqp.Message{})
+	require.NoError(t, err)
+	require.EqualValues(t, 1, link.Generation())
+}
+
+func TestLinkResendsInFlightRequests(t *testing.T) {
+	first := detachingBroker()
+	link := newTestLink(t, first, LinkWithResendOnRecovery())
+	second := okBroker()
+	withReattach(link, second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := link.RPC(ctx, &amqp.Message{})
+	require.NoError(t, err)
+	require.Equal(t, 200, res.Code)
+	require.EqualValues(t, 1, link.Generation())
+
+	require.Len(t, first.Sent(), 1)
+	require.Len(t, second.Sent(), 1)
+	require.Equal(t, first.Sent()[0].Properties.MessageID, second.Sent()[0].Properties.MessageID)
+}
+
+func TestLinkReportsDetachWithRemoteError(t *testing.T) {
+	var first *fakeBroker
+	first = newFakeBroker(func(req *amqp.Message) *amqp.Message {
+		first.detach(&amqp.Error{Condition: amqp.ErrCondUnauthorizedAccess, Description: "token expired"})
+		return nil
+	})
+	link := newTestLink(t, first, LinkWithResendOnRecovery())
+	second := okBroker()
+	withReattach(link, second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := link.RPC(ctx, &amqp.Message{})
+	var linkErr *amqp.LinkError
+	require.ErrorAs(t, err, &linkErr)
+	require.NotNil(t, linkErr.RemoteErr, "the reason given by the service is not swallowed by recovery")
+	require.Equal(t, amqp.ErrCondUnauthorizedAccess, linkErr.RemoteErr.Condition)
+	require.Empty(t, second.Sent())
+	require.True(t, IsDetached(err))
+	require.False(t, isClosedError(err))
+
+	// the link still recovers for the next request
+	_, err = link.RPC(ctx, &amqp.Message{})
+	require.NoError(t, err)
+	require.EqualValues(t, 1, link.Generation())
+}
+
+func TestLinkDoesNotRecoverAfterClose(t *testing.T) {
+	first := okBroker()
+	link := newTestLink(t, first, LinkWithRecovery())
+	calls := withReattach(link, okBroker())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := link.RPC(ctx, &amqp.Message{})
+	require.NoError(t, err)
+	require.NoError(t, link.Close(ctx))
+	require.Eventually(t, func() bool { return link.addChannelToMap("probe") == nil }, 5*time.Second, 10*time.Millisecond)
+
+	_, err = link.RPC(ctx, &amqp.Message{})
+	require.Error(t, err)
+	require.EqualValues(t, 0, atomic.LoadInt32(calls))
+}
@@ -0,0 +1,340 @@
+package rpc
+
+//	MIT License
+//
+//	Copyright (c) Microsoft Corporation. All rights reserved.
+//
+//	Permission is hereby granted, free of charge, to any person obtaining a copy
+//	of this software and associated documentation files (the "Software"), to deal
+//	in the Software without restriction, including without limitation the rights
+//	to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//	copies of the Software, and to permit persons to whom the Software is
+//	furnished to do so, subject to the following conditions:
+//
+//	The above copyright notice and this permission notice shall be included in all
+//	copies or substantial portions of the Software.
+//
+//	THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//	AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//	LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//	OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//	SOFTWARE
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/Azure/go-amqp"
+)
+
+// maxPendingExchanges is how many requests a Recorder waits on a response for. Beyond it, the requests which have
+// waited longest are recorded without a response.
+const maxPendingExchanges = 1024
+
+// replayIgnoredProperties are the application properties which differ between runs of the same request, and so are
+// not compared when matching requests to a recording
+var replayIgnoredProperties = []string{"server-timeout"}
+
+type (
+	// Recorder captures the requests a Link sends and the responses it receives, so they can be saved as a Recording
+	// and replayed with NewReplayLink. A Recorder may be shared by several links.
+	Recorder struct {
+		mu        sync.Mutex
+		exchanges []*Exchange
+		pending   []pendingExchange // in the order the requests were sent
+		err       error
+	}
+
+	// pendingExchange is a recorded request which is waiting on its response
+	pendingExchange struct {
+		messageID string
+		exchange  *Exchange
+	}
+
+	recordingSender struct {
+		amqpSender
+		recorder *Recorder
+	}
+
+	recordingReceiver struct {
+		amqpReceiver
+		recorder *Recorder
+	}
+
+	// replayTransport is both ends of a Link which answers requests from a Recording
+	replayTransport struct {
+		mu        sync.Mutex
+		exchanges []Exchange
+		used      []bool
+		responses chan *amqp.Message
+		closed    chan struct{}
+		closeOnce sync.Once
+	}
+)
+
+// NewRecorder builds an empty Recorder
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// LinkWithRecorder records every request the Link sends and every response it receives with recorder
+func LinkWithRecorder(recorder *Recorder) LinkOption {
+	return func(l *Link) error {
+		if recorder == nil {
+			return errors.New("recorder must not be nil")
+		}
+		l.recorder = recorder
+		return nil
+	}
+}
+
+// Recording returns what has been recorded so far. The error is the first message which could not be recorded, if
+// any; those messages are left out of the recording.
+func (r *Recorder) Recording() (*Recording, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	recording := &Recording{
+		Exchanges: make([]Exchange, len(r.exchanges)),
+	}
+	for i, exchange := range r.exchanges {
+		recording.Exchanges[i] = *exchange
+	}
+	return recording, r.err
+}
+
+// request records a request and returns its exchange, or nil if it could not be recorded. Several requests may share
+// a message ID, such as the attempts of a request with a stable message ID; each is recorded as its own exchange.
+func (r *Recorder) request(msg *amqp.Message) *Exchange {
+	recorded, err := NewRecordedMessage(msg)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err != nil {
+		r.fail(err)
+		return nil
+	}
+
+	messageID, _ := msg.Properties.MessageID.(string)
+	exchange := &Exchange{Request: recorded}
+	r.exchanges = append(r.exchanges, exchange)
+
+	if len(r.pending) >= maxPendingExchanges {
+		// the oldest request is unlikely to ever be answered
+		r.pending = r.pending[1:]
+	}
+	r.pending = append(r.pending, pendingExchange{messageID: messageID, exchange: exchange})
+	return exchange
+}
+
+// forget removes a request which could not be sent
+func (r *Recorder) forget(exchange *Exchange) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, p := range r.pending {
+		if p.exchange == exchange {
+			r.pending = append(r.pending[:i], r.pending[i+1:]...)
+			break
+		}
+	}
+
+	for i, e := range r.exchanges {
+		if e == exchange {
+			r.exchanges = append(r.exchanges[:i], r.exchanges[i+1:]...)
+			break
+		}
+	}
+}
+
+func (r *Recorder) response(msg *amqp.Message) {
+	if msg.Properties == nil {
+		return
+	}
+	correlationID, _ := msg.Properties.CorrelationID.(string)
+	recorded, err := NewRecordedMessage(msg)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err != nil {
+		r.fail(err)
+		return
+	}
+
+	// a response answers the earliest request with its message ID which is still waiting on one
+	for i, p := range r.pending {
+		if p.messageID == correlationID {
+			r.pending = append(r.pending[:i], r.pending[i+1:]...)
+			p.exchange.Response = recorded
+			return
+		}
+	}
+}
+
+func (r *Recorder) fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+// record wraps sender and receiver so that they record with the recorder of the link, if it has one
+func (l *Link) record(sender amqpSender, receiver amqpReceiver) (amqpSender, amqpReceiver) {
+	if l.recorder == nil {
+		return sender, receiver
+	}
+	return &recordingSender{amqpSender: sender, recorder: l.recorder},
+		&recordingReceiver{amqpReceiver: receiver, recorder: l.recorder}
+}
+
+func (s *recordingSender) Send(ctx context.Context, msg *amqp.Message, o *amqp.SendOptions) error {
+	exchange := s.recorder.request(msg)
+
+	if err := s.amqpSender.Send(ctx, msg, o); err != nil {
+		if exchange != nil {
+			s.recorder.forget(exchange)
+		}
+		return err
+	}
+	return nil
+}
+
+func (r *recordingReceiver) Receive(ctx context.Context, o *amqp.ReceiveOptions) (*amqp.Message, error) {
+	msg, err := r.amqpReceiver.Receive(ctx, o)
+	if err == nil && msg != nil {
+		r.recorder.response(msg)
+	}
+	return msg, err
+}
+
+// NewReplayLink builds a Link which answers requests from recording instead of sending them to a service. A request
+// is answered with the response of the first exchange not yet replayed whose request matches it; message IDs, reply
+// addresses and server timeouts are not compared, since they differ between runs. Sending a request which matches no
+// exchange fails.
+func NewReplayLink(recording *Recording, opts ...LinkOption) (*Link, error) {
+	link, err := newLink("replay", nil, "$replay", opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := &replayTransport{
+		exchanges: recording.Exchanges,
+		used:      make([]bool, len(recording.Exchanges)),
+		responses: make(chan *amqp.Message, len(recording.Exchanges)),
+		closed:    make(chan struct{}),
+	}
+
+	link.sender, link.receiver = link.record(transport, transport)
+	link.messageAccept = func(ctx context.Context, message *amqp.Message) error {
+		return nil
+	}
+	return link, nil
+}
+
+// Send implements amqpSender
+func (t *replayTransport) Send(ctx context.Context, msg *amqp.Message, o *amqp.SendOptions) error {
+	select {
+	case <-t.closed:
+		return &amqp.LinkError{}
+	default:
+	}
+
+	key, err := replayKey(msg)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for i, exchange := range t.exchanges {
+		if t.used[i] || exchange.Request == nil {
+			continue
+		}
+
+		recordedKey, err := replayKey(exchange.Request)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(key, recordedKey) {
+			continue
+		}
+
+		t.used[i] = true
+		if exchange.Response == nil {
+			// the service never answered this request
+			return nil
+		}
+
+		res, err := exchange.Response.Message()
+		if err != nil {
+			return err
+		}
+		res.Properties.CorrelationID = msg.Properties.MessageID
+		t.responses <- res
+		return nil
+	}
+
+	return fmt.Errorf("rpc: no recorded exchange matches the request %s", key)
+}
+
+// Receive implements amqpReceiver
+func (t *replayTransport) Receive(ctx context.Context, o *amqp.ReceiveOptions) (*amqp.Message, error) {
+	select {
+	case res := <-t.responses:
+		return res, nil
+	case <-t.closed:
+		return nil, &amqp.LinkError{}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Close implements amqpSender and amqpReceiver
+func (t *replayTransport) Close(ctx context.Context) error {
+	t.closeOnce.Do(func() { close(t.closed) })
+	return nil
+}
+
+// replayKey is the canonical form of a request used to match it with a recording: its application properties and
+// body, without the values which change from one run to the next
+func replayKey(request interface{}) ([]byte, error) {
+	var recorded *RecordedMessage
+	switch request := request.(type) {
+	case *amqp.Message:
+		var err error
+		if recorded, err = NewRecordedMessage(request); err != nil {
+			return nil, err
+		}
+	case *RecordedMessage:
+		copied := *request
+		recorded = &copied
+	}
+
+	properties := make(map[string]TypedValue, len(recorded.ApplicationProperties))
+	for k, v := range recorded.ApplicationProperties {
+		properties[k] = v
+	}
+	for _, k := range replayIgnoredProperties {
+		delete(properties, k)
+	}
+
+	return json.Marshal(struct {
+		Subject               string                `json:"subject,omitempty"`
+		ApplicationProperties map[string]TypedValue `json:"applicationProperties,omitempty"`
+		Value                 *TypedValue           `json:"value,omitempty"`
+		Data                  [][]byte              `json:"data,omitempty"`
+	}{
+		Subject:               recorded.Subject,
+		ApplicationProperties: properties,
+		Value:                 recorded.Value,
+		Data:                  recorded.Data,
+	})
+}
@@ -0,0 +1,232 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-amqp"
+	"github.com/stretchr/testify/require"
+)
+
+func recordedBroker() *fakeBroker {
+	return newFakeBroker(func(req *amqp.Message) *amqp.Message {
+		res := statusResponse(200, "OK")
+		res.Value = map[string]interface{}{
+			"count":    int64(42),
+			"lockedAt": time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+			"lockId":   amqp.UUID{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16},
+			"name":     req.ApplicationProperties["name"],
+		}
+		return res
+	})
+}
+
+func request(name string) *amqp.Message {
+	return &amqp.Message{
+		ApplicationProperties: map[string]interface{}{
+			"operation":      "com.microsoft:peek-message",
+			"name":           name,
+			"server-timeout": uint32(60000),
+		},
+	}
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	recorder := NewRecorder()
+	broker := recordedBroker()
+	link := newTestLink(t, broker, LinkWithRecorder(recorder))
+	link.sender, link.receiver = link.record(broker, broker)
+
+	recordedFirst, err := link.RPC(context.Background(), request("first"))
+	require.NoError(t, err)
+	_, err = link.RPC(context.Background(), request("second"))
+	require.NoError(t, err)
+
+	recording, err := recorder.Recording()
+	require.NoError(t, err)
+	require.Len(t, recording.Exchanges, 2)
+	require.NotNil(t, recording.Exchanges[0].Response)
+
+	var golden bytes.Buffer
+	require.NoError(t, recording.Save(&golden))
+	require.Contains(t, golden.String(), `"type": "timestamp"`)
+
+	loaded, err := LoadRecording(&golden)
+	require.NoError(t, err)
+
+	replay, err := NewReplayLink(loaded)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, replay.Close(context.Background())) }()
+
+	// requests are matched by content, not by the order they were recorded in
+	res, err := replay.RPC(context.Background(), request("second"))
+	require.NoError(t, err)
+	require.Equal(t, "second", res.Message.Value.(map[string]interface{})["name"])
+
+	req := request("first")
+	req.ApplicationProperties["server-timeout"] = uint32(30000)
+	res, err = replay.RPC(context.Background(), req)
+	require.NoError(t, err)
+	require.Equal(t, recordedFirst.Code, res.Code)
+	require.Equal(t, recordedFirst.Message.Value, res.Message.Value)
+	require.IsType(t, int32(0), res.Message.ApplicationProperties["status-code"])
+}
+
+func TestReplayUnmatchedRequest(t *testing.T) {
+	replay, err := NewReplayLink(&Recording{
+		Exchanges: []Exchange{{
+			Request:  &RecordedMessage{Subject: "known"},
+			Response: &RecordedMessage{ApplicationProperties: map[string]TypedValue{"status-code": {Type: "int", Value: []byte("200")}}},
+		}},
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, replay.Close(context.Background())) }()
+
+	_, err = replay.RPC(context.Background(), request("unknown"))
+	require.ErrorContains(t, err, "no recorded exchange matches")
+
+	// each exchange is replayed once
+	subject := "known"
+	res, err := replay.RPC(context.Background(), &amqp.Message{Properties: &amqp.MessageProperties{Subject: &subject}})
+	require.NoError(t, err)
+	require.Equal(t, 200, res.Code)
+
+	_, err = replay.RPC(context.Background(), &amqp.Message{Properties: &amqp.MessageProperties{Subject: &subject}})
+	require.Error(t, err)
+}
+
+func TestRecordedMessageRoundTrip(t *testing.T) {
+	msg := &amqp.Message{
+		Properties: &amqp.MessageProperties{MessageID: "id"},
+		ApplicationProperties: map[string]interface{}{
+			"binary": []byte{1, 2},
+			"short":  int16(-3),
+			"list":   []interface{}{uint8(1), "two", nil},
+			"keyed":  map[interface{}]interface{}{int32(1): true},
+		},
+		Value: 1.5,
+	}
+
+	recorded, err := NewRecordedMessage(msg)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, (&Recording{Exchanges: []Exchange{{Request: recorded}}}).Save(&buf))
+	loaded, err := LoadRecording(&buf)
+	require.NoError(t, err)
+
+	replayed, err := loaded.Exchanges[0].Request.Message()
+	require.NoError(t, err)
+	require.Equal(t, msg.ApplicationProperties, replayed.ApplicationProperties)
+	require.Equal(t, msg.Value, replayed.Value)
+	require.Equal(t, "id", replayed.Properties.MessageID)
+
+	_, err = NewRecordedMessage(&amqp.Message{Value: struct{}{}})
+	require.Error(t, err)
+}
+
+func TestRecordedSymbolsRoundTrip(t *testing.T) {
+	symbol := func(s string) interface{} { return reflect.ValueOf(s).Convert(symbolType).Interface() }
+	symbolKeyed := reflect.MakeMap(reflect.MapOf(symbolType, reflect.TypeOf((*interface{})(nil)).Elem()))
+	symbolKeyed.SetMapIndex(reflect.ValueOf(symbol("com.microsoft:key")), reflect.ValueOf(int32(1)))
+	symbols := reflect.MakeSlice(reflect.SliceOf(symbolType), 0, 2)
+	symbols = reflect.Append(symbols, reflect.ValueOf(symbol("a")), reflect.ValueOf(symbol("b")))
+
+	msg := &amqp.Message{
+		ApplicationProperties: map[string]interface{}{
+			"symbol": symbol("com.microsoft:server-busy"),
+			"ints":   []int32{1, 2},
+		},
+		Value: map[string]interface{}{
+			"keys":    symbolKeyed.Interface(),
+			"symbols": symbols.Interface(),
+			"mixed":   map[interface{}]interface{}{symbol("symbol"): true, int32(1): false},
+		},
+	}
+
+	recorded, err := NewRecordedMessage(msg)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, (&Recording{Exchanges: []Exchange{{Request: recorded}}}).Save(&buf))
+	require.Contains(t, buf.String(), `"type": "symbol"`)
+	loaded, err := LoadRecording(&buf)
+	require.NoError(t, err)
+
+	replayed, err := loaded.Exchanges[0].Request.Message()
+	require.NoError(t, err)
+	require.Equal(t, msg.ApplicationProperties, replayed.ApplicationProperties)
+	require.Equal(t, msg.Value, replayed.Value)
+
+	// the replayed values are encoded with the same AMQP types
+	encoded := func(v interface{}) []byte {
+		raw, err := (&amqp.Message{Value: v}).MarshalBinary()
+		require.NoError(t, err)
+		return raw
+	}
+	for k, v := range msg.ApplicationProperties {
+		require.Equal(t, encoded(v), encoded(replayed.ApplicationProperties[k]), k)
+	}
+	for _, k := range []string{"keys", "symbols"} {
+		require.Equal(t, encoded(msg.Value.(map[string]interface{})[k]), encoded(replayed.Value.(map[string]interface{})[k]), k)
+	}
+}
+
+func TestRecorderKeepsEveryAttemptWithStableIDs(t *testing.T) {
+	recorder := NewRecorder()
+	broker := newFakeBroker(nil)
+	link := newTestLink(t, broker, LinkWithRecorder(recorder))
+	link.sender, link.receiver = link.record(broker, broker)
+	ctx := context.Background()
+
+	// the first attempt gives up before its response arrives
+	attemptCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err := link.RPCWithIdempotencyKey(attemptCtx, "key", request("first"))
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := link.RPCWithIdempotencyKey(ctx, "key", request("first"))
+		result <- err
+	}()
+	require.Eventually(t, func() bool { return len(broker.Sent()) == 2 }, 5*time.Second, 10*time.Millisecond)
+
+	respondTo(broker, broker.Sent()[0])
+	require.NoError(t, <-result)
+	respondTo(broker, broker.Sent()[1])
+
+	require.Eventually(t, func() bool {
+		recording, err := recorder.Recording()
+		require.NoError(t, err)
+		return len(recording.Exchanges) == 2 && recording.Exchanges[1].Response != nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	recording, err := recorder.Recording()
+	require.NoError(t, err)
+	require.NotNil(t, recording.Exchanges[0].Response, "the first attempt is answered by the first response")
+}
+
+func TestRecorderDropsStalePendingExchanges(t *testing.T) {
+	recorder := NewRecorder()
+
+	for i := 0; i <= maxPendingExchanges; i++ {
+		require.NotNil(t, recorder.request(&amqp.Message{Properties: &amqp.MessageProperties{MessageID: fmt.Sprint(i)}}))
+	}
+	require.Len(t, recorder.pending, maxPendingExchanges)
+
+	// the response to the oldest request arrives too late to be recorded
+	recorder.response(&amqp.Message{Properties: &amqp.MessageProperties{CorrelationID: "0"}})
+	recorder.response(&amqp.Message{Properties: &amqp.MessageProperties{CorrelationID: "1"}})
+
+	recording, err := recorder.Recording()
+	require.NoError(t, err)
+	require.Len(t, recording.Exchanges, maxPendingExchanges+1)
+	require.Nil(t, recording.Exchanges[0].Response)
+	require.NotNil(t, recording.Exchanges[1].Response)
+	require.Len(t, recorder.pending, maxPendingExchanges-1)
+}
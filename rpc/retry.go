@@ -0,0 +1,203 @@
+package rpc
+
+//	MIT License
+//
+//	Copyright (c) Microsoft Corporation. All rights reserved.
+//
+//	Permission is hereby granted, free of charge, to any person obtaining a copy
+//	of this software and associated documentation files (the "Software"), to deal
+//	in the Software without restriction, including without limitation the rights
+//	to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//	copies of the Software, and to permit persons to whom the Software is
+//	furnished to do so, subject to the following conditions:
+//
+//	The above copyright notice and this permission notice shall be included in all
+//	copies or substantial portions of the Software.
+//
+//	THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//	AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//	LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//	OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//	SOFTWARE
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	common "github.com/Azure/azure-amqp-common-go/v4"
+)
+
+type (
+	// RetryPolicy decides whether, and after how long, a failed request is retried
+	RetryPolicy interface {
+		// NextDelay returns the delay before the given retry, numbered from 1, and false if the request should not be
+		// retried. elapsed is the time since the first attempt started.
+		NextDelay(retry int, elapsed time.Duration) (time.Duration, bool)
+	}
+
+	// FixedRetryPolicy makes up to MaxAttempts attempts, waiting Delay between each of them
+	FixedRetryPolicy struct {
+		// MaxAttempts is the total number of attempts, including the first
+		MaxAttempts int
+		// Delay is the wait between attempts
+		Delay time.Duration
+	}
+
+	// ExponentialRetryPolicy doubles the delay between attempts, starting from BaseDelay, and randomizes each delay to
+	// keep clients which failed at the same time from retrying in lockstep. A policy with neither MaxAttempts nor
+	// MaxElapsed set makes up to 3 attempts.
+	ExponentialRetryPolicy struct {
+		// MaxAttempts is the total number of attempts, including the first. Zero means no limit, as long as
+		// MaxElapsed is set.
+		MaxAttempts int
+		// BaseDelay is the delay before the first retry
+		BaseDelay time.Duration
+		// MaxDelay caps each delay. Zero means no cap.
+		MaxDelay time.Duration
+		// MaxElapsed stops retrying once the next attempt would start more than MaxElapsed after the first one. Zero
+		// means no limit.
+		MaxElapsed time.Duration
+		// Jitter is the fraction, between 0 and 1, by which each delay is randomly reduced
+		Jitter float64
+	}
+)
+
+// defaultMaxAttempts is the number of attempts made by the default policy, and by an ExponentialRetryPolicy without
+// any bound
+const defaultMaxAttempts = 3
+
+var defaultRetryPolicy RetryPolicy = FixedRetryPolicy{MaxAttempts: defaultMaxAttempts, Delay: 1 * time.Second}
+
+// LinkWithRetryPolicy configures the policy RetryableRPCWithPolicy uses when it is not given one
+func LinkWithRetryPolicy(policy RetryPolicy) LinkOption {
+	return func(l *Link) error {
+		if policy == nil {
+			return errors.New("retry policy must not be nil")
+		}
+		l.retryPolicy = policy
+		return nil
+	}
+}
+
+// NextDelay implements RetryPolicy
+func (p FixedRetryPolicy) NextDelay(retry int, _ time.Duration) (time.Duration, bool) {
+	return p.Delay, retry < p.MaxAttempts
+}
+
+// NextDelay implements RetryPolicy
+func (p ExponentialRetryPolicy) NextDelay(retry int, elapsed time.Duration) (time.Duration, bool) {
+	if p.MaxAttempts == 0 && p.MaxElapsed == 0 {
+		p.MaxAttempts = defaultMaxAttempts
+	}
+
+	if p.MaxAttempts > 0 && retry >= p.MaxAttempts {
+		return 0, false
+	}
+
+	delay := p.BaseDelay
+	for i := 1; i < retry && (p.MaxDelay == 0 || delay < p.MaxDelay); i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+
+	if p.Jitter > 0 {
+		jitter := p.Jitter
+		if jitter > 1 {
+			jitter = 1
+		}
+		delay -= time.Duration(rand.Float64() * jitter * float64(delay))
+	}
+
+	if p.MaxElapsed > 0 && elapsed+delay > p.MaxElapsed {
+		return 0, false
+	}
+	return delay, true
+}
+
+// retry calls action until it succeeds, fails with an error which is not retryable, or policy stops allowing retries.
+// When the service is throttling the client, retries wait at least as long as it asked, up to maxThrottle, and give up
+// with the throttling error if ctx would be done before then. Waits between attempts end early if ctx is done.
+func retry(ctx context.Context, policy RetryPolicy, maxThrottle time.Duration, canRecover bool, action func() (*Response, error)) (*Response, error) {
+	start := time.Now()
+	var lastErr error
+
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 {
+			delay, ok := policy.NextDelay(attempt-1, time.Since(start))
+			if !ok {
+				return nil, lastErr
+			}
+
+			if hint, throttled := throttleDelay(lastErr); throttled {
+				if hint > maxThrottle {
+					hint = maxThrottle
+				}
+				if hint > delay {
+					delay = hint
+				}
+
+				if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+					return nil, lastErr
+				}
+			}
+
+			if err := sleep(ctx, delay); err != nil {
+				return nil, err
+			}
+		}
+
+		res, err := action()
+		if err == nil {
+			return res, nil
+		}
+
+		if !isRetryable(err, canRecover) {
+			return nil, err
+		}
+		lastErr = err
+	}
+}
+
+// isRetryable returns true for errors which report themselves as retryable through a Retryable method, such as status
+// errors worth retrying, for throttling and for errors marked as common.Retryable. A detach is only worth retrying if
+// the link can recover from it; otherwise every attempt would fail on the dead link.
+func isRetryable(err error, canRecover bool) bool {
+	if !canRecover && IsDetached(err) {
+		return false
+	}
+
+	var retryable interface{ Retryable() bool }
+	if errors.As(err, &retryable) {
+		return retryable.Retryable()
+	}
+
+	if _, throttled := throttleDelay(err); throttled {
+		return true
+	}
+
+	var marked common.Retryable
+	return errors.As(err, &marked)
+}
+
+// sleep waits for delay, returning early with the context's error if ctx is done first
+func sleep(ctx context.Context, delay time.Duration) error {
+	if delay <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,166 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-amqp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFixedRetryPolicy(t *testing.T) {
+	p := FixedRetryPolicy{MaxAttempts: 3, Delay: time.Second}
+
+	delay, ok := p.NextDelay(1, 0)
+	require.True(t, ok)
+	require.Equal(t, time.Second, delay)
+
+	_, ok = p.NextDelay(2, 0)
+	require.True(t, ok)
+
+	_, ok = p.NextDelay(3, 0)
+	require.False(t, ok)
+}
+
+func TestExponentialRetryPolicy(t *testing.T) {
+	p := ExponentialRetryPolicy{
+		MaxAttempts: 6,
+		BaseDelay:   100 * time.Millisecond,
+		MaxDelay:    time.Second,
+	}
+
+	var delays []time.Duration
+	for retry := 1; ; retry++ {
+		delay, ok := p.NextDelay(retry, 0)
+		if !ok {
+			break
+		}
+		delays = append(delays, delay)
+	}
+	require.Equal(t, []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+	}, delays)
+
+	p.MaxElapsed = 2 * time.Second
+	_, ok := p.NextDelay(3, 1700*time.Millisecond)
+	require.False(t, ok, "the next attempt would start after MaxElapsed")
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		delay, ok := p.NextDelay(4, 0)
+		require.True(t, ok)
+		require.GreaterOrEqual(t, delay, 400*time.Millisecond)
+		require.LessOrEqual(t, delay, 800*time.Millisecond)
+	}
+}
+
+func TestExponentialRetryPolicyIsBounded(t *testing.T) {
+	p := ExponentialRetryPolicy{BaseDelay: time.Millisecond}
+
+	retries := 0
+	for ; retries < 100; retries++ {
+		if _, ok := p.NextDelay(retries+1, 0); !ok {
+			break
+		}
+	}
+	require.Equal(t, defaultMaxAttempts-1, retries)
+
+	// MaxElapsed alone is enough of a bound
+	p.MaxElapsed = time.Hour
+	_, ok := p.NextDelay(10, 0)
+	require.True(t, ok)
+}
+
+func TestRetryableRPCWithPolicy(t *testing.T) {
+	codes := []int32{503, 500, 200}
+	broker := newFakeBroker(func(req *amqp.Message) *amqp.Message {
+		code := codes[0]
+		codes = codes[1:]
+		return statusResponse(code, "")
+	})
+
+	link := newTestLink(t, broker, LinkWithRetryPolicy(FixedRetryPolicy{MaxAttempts: 3, Delay: time.Millisecond}))
+	res, err := link.RetryableRPCWithPolicy(context.Background(), nil, &amqp.Message{})
+	require.NoError(t, err)
+	require.Equal(t, 200, res.Code)
+	require.Len(t, broker.Sent(), 3)
+}
+
+func TestRetryableRPCGivesUp(t *testing.T) {
+	broker := newFakeBroker(func(req *amqp.Message) *amqp.Message {
+		return statusResponse(500, "busy")
+	})
+
+	link := newTestLink(t, broker)
+	_, err := link.RetryableRPC(context.Background(), 2, time.Millisecond, &amqp.Message{})
+	require.Error(t, err)
+	require.Len(t, broker.Sent(), 2)
+}
+
+func TestRetryableRPCStopsWaitingWhenCancelled(t *testing.T) {
+	broker := newFakeBroker(func(req *amqp.Message) *amqp.Message {
+		return statusResponse(500, "busy")
+	})
+
+	link := newTestLink(t, broker)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := link.RetryableRPC(ctx, 3, time.Hour, &amqp.Message{})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Less(t, time.Since(start), time.Minute)
+	require.Len(t, broker.Sent(), 1)
+}
+
+func TestRetryableRPCDerivesServerTimeoutPerAttempt(t *testing.T) {
+	broker := flakyBroker(1)
+	link := newTestLink(t, broker)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	msg := &amqp.Message{ApplicationProperties: map[string]interface{}{"operation": "op"}}
+	_, err := link.RetryableRPCWithPolicy(ctx, FixedRetryPolicy{MaxAttempts: 2, Delay: 50 * time.Millisecond}, msg)
+	require.NoError(t, err)
+
+	sent := broker.Sent()
+	require.Len(t, sent, 2)
+	require.Less(t, sent[1].ApplicationProperties["server-timeout"], sent[0].ApplicationProperties["server-timeout"])
+	require.Equal(t, map[string]interface{}{"operation": "op"}, msg.ApplicationProperties, "the request of the caller is not changed")
+}
+
+// selfRetryable is an error which decides for itself whether it is worth retrying, as cbs.ClaimError does
+type selfRetryable bool
+
+func (e selfRetryable) Error() string   { return "self retryable" }
+func (e selfRetryable) Retryable() bool { return bool(e) }
+
+func TestRetryableRPCAsksErrorsWhetherToRetry(t *testing.T) {
+	for _, retryable := range []bool{true, false} {
+		t.Run(fmt.Sprint(retryable), func(t *testing.T) {
+			attempts := 0
+			link := newTestLink(t, flakyBroker(0), LinkWithInterceptors(func(ctx context.Context, msg *amqp.Message, invoker Invoker) (*Response, error) {
+				attempts++
+				if attempts == 1 {
+					return nil, fmt.Errorf("wrapped: %w", selfRetryable(retryable))
+				}
+				return invoker(ctx, msg)
+			}))
+
+			_, err := link.RetryableRPCWithPolicy(context.Background(), FixedRetryPolicy{MaxAttempts: 2, Delay: time.Millisecond}, &amqp.Message{})
+			if retryable {
+				require.NoError(t, err)
+				require.Equal(t, 2, attempts)
+			} else {
+				require.ErrorIs(t, err, selfRetryable(false))
+				require.Equal(t, 1, attempts)
+			}
+		})
+	}
+}
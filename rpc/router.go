@@ -0,0 +1,103 @@
+package rpc
+
+//	MIT License
+//
+//	Copyright (c) Microsoft Corporation. All rights reserved.
+//
+//	Permission is hereby granted, free of charge, to any person obtaining a copy
+//	of this software and associated documentation files (the "Software"), to deal
+//	in the Software without restriction, including without limitation the rights
+//	to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//	copies of the Software, and to permit persons to whom the Software is
+//	furnished to do so, subject to the following conditions:
+//
+//	The above copyright notice and this permission notice shall be included in all
+//	copies or substantial portions of the Software.
+//
+//	THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//	AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//	LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//	OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//	SOFTWARE
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+const (
+	minRouterBackoff = 100 * time.Millisecond
+	maxRouterBackoff = 5 * time.Second
+)
+
+// LinkWithReceiveErrorHandler registers a handler for transient errors receiving responses. The Link backs off after
+// each of them before receiving again; errors which close the link are not passed to the handler, since they fail the
+// outstanding requests instead. The handler is called from the goroutine which receives responses, so it must not
+// block.
+func LinkWithReceiveErrorHandler(handler func(err error)) LinkOption {
+	return func(l *Link) error {
+		if handler == nil {
+			return errors.New("receive error handler must not be nil")
+		}
+		l.receiveErrorHandler = handler
+		return nil
+	}
+}
+
+// lifetime returns a context which is done once the link is closed
+func (l *Link) lifetime() context.Context {
+	if l.routerCtx == nil {
+		return context.Background()
+	}
+	return l.routerCtx
+}
+
+// runResponseRouter starts the response router for the current generation, unless the link has been closed
+func (l *Link) runResponseRouter() {
+	l.responseMu.Lock()
+	defer l.responseMu.Unlock()
+
+	if l.closed {
+		return
+	}
+
+	l.routers.Add(1)
+	go func() {
+		defer l.routers.Done()
+		l.startResponseRouter()
+	}()
+}
+
+// stopResponseRouters stops every response router of the link and waits for them to exit, or for ctx to be done
+func (l *Link) stopResponseRouters(ctx context.Context) error {
+	if l.stopRouters != nil {
+		l.stopRouters()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		l.routers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// receiveError counts a transient receive error and passes it to the receive error handler, if there is one
+func (l *Link) receiveError(err error) {
+	l.responseMu.Lock()
+	l.stats.ReceiveErrors++
+	l.responseMu.Unlock()
+
+	if l.receiveErrorHandler != nil {
+		l.receiveErrorHandler(err)
+	}
+}
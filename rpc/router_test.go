@@ -0,0 +1,94 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-amqp"
+	"github.com/stretchr/testify/require"
+)
+
+// stuckLink is an amqpSender and amqpReceiver whose Receive only returns once its context is done, and whose Close
+// does not unblock it
+type stuckLink struct{}
+
+func (stuckLink) Send(ctx context.Context, msg *amqp.Message, o *amqp.SendOptions) error { return nil }
+
+func (stuckLink) Receive(ctx context.Context, o *amqp.ReceiveOptions) (*amqp.Message, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (stuckLink) Close(ctx context.Context) error { return nil }
+
+func TestResponseRouterReportsTransientErrors(t *testing.T) {
+	first, second := errors.New("first"), errors.New("second")
+	receiver := &fakeReceiver{
+		Responses: []rpcResponse{
+			{nil, first},
+			{nil, second},
+			{amqpMessageWithCorrelationId("my message id"), nil},
+			{nil, &amqp.LinkError{}},
+		},
+	}
+
+	var mu sync.Mutex
+	var reported []error
+	link := &Link{
+		responseMap: map[string]chan rpcResponse{
+			"my message id": make(chan rpcResponse, 1),
+		},
+		receiver: receiver,
+		receiveErrorHandler: func(err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			reported = append(reported, err)
+		},
+	}
+	ch := link.responseMap["my message id"]
+
+	link.startResponseRouter()
+
+	require.NotNil(t, (<-ch).message, "routing continues after transient errors")
+	require.Equal(t, []error{first, second}, reported)
+	require.EqualValues(t, 2, link.Stats().ReceiveErrors)
+}
+
+func TestCloseStopsResponseRouter(t *testing.T) {
+	link, err := newLink("test-link", nil, "$management")
+	require.NoError(t, err)
+	link.sender, link.receiver = stuckLink{}, stuckLink{}
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := link.RPC(context.Background(), &amqp.Message{})
+		result <- err
+	}()
+	require.Eventually(t, func() bool { return link.Stats().InFlight == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, link.Close(ctx))
+
+	// Close only returns once the router has exited
+	done := make(chan struct{})
+	go func() {
+		link.routers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(100 * time.Millisecond):
+		require.Fail(t, "response router is still running")
+	}
+
+	require.ErrorIs(t, <-result, ErrLinkClosed)
+}
+
+func TestLinkWithReceiveErrorHandlerRejectsNil(t *testing.T) {
+	_, err := newLink("test-link", nil, "$management", LinkWithReceiveErrorHandler(nil))
+	require.Error(t, err)
+}
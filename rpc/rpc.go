@@ -33,7 +33,6 @@ import (
 
 	"github.com/devigned/tab"
 
-	common "github.com/Azure/azure-amqp-common-go/v4"
 	"github.com/Azure/azure-amqp-common-go/v4/internal/tracing"
 	"github.com/Azure/azure-amqp-common-go/v4/uuid"
 	"github.com/Azure/go-amqp"
@@ -49,7 +48,10 @@ const (
 type (
 	// Link is the bidirectional communication structure used for CBS negotiation
 	Link struct {
-		session *amqp.Session
+		conn          *amqp.Conn
+		session       *amqp.Session
+		sharedSession bool
+		address       string
 
 		receiver amqpReceiver // *amqp.Receiver
 		sender   amqpSender   // *amqp.Sender
@@ -62,10 +64,47 @@ type (
 		responseMu              sync.Mutex
 		startResponseRouterOnce *sync.Once
 		responseMap             map[string]chan rpcResponse
+		generation              uint64
+		closed                  bool
+		stats                   LinkStats
+		recent                  map[string]OrphanReason
+		recentOrder             []string
+
+		orphanHandler OrphanHandler
+		recorder      *Recorder
+		interceptors  []Interceptor
+
+		drained chan struct{}
+
+		routerCtx           context.Context
+		stopRouters         context.CancelFunc
+		routers             sync.WaitGroup
+		receiveErrorHandler func(err error)
+
+		receiverCredit     int32
+		senderSettleMode   *amqp.SenderSettleMode
+		receiverSettleMode *amqp.ReceiverSettleMode
+		namePrefix         string
+		linkProperties     map[string]interface{}
+		capabilities       []string
+		maxMessageSize     uint64
+
+		maxInFlight   int
+		limitInFlight bool
+		inFlight      chan struct{}
+
+		retryPolicy      RetryPolicy
+		stableMessageIDs bool
+		maxThrottleDelay time.Duration
+
+		recovery       bool
+		resendInFlight bool
+		recoverMu      sync.Mutex
 
 		// for unit tests
 		uuidNewV4     func() (uuid.UUID, error)
 		messageAccept func(ctx context.Context, message *amqp.Message) error
+		reattach      func(ctx context.Context) (amqpSender, amqpReceiver, acceptFunc, error)
 	}
 
 	// Response is the simplified response structure from an RPC like call
@@ -83,6 +122,15 @@ type (
 		err     error
 	}
 
+	acceptFunc func(ctx context.Context, message *amqp.Message) error
+
+	// endpoints are the sender and receiver of a single generation of a Link
+	endpoints struct {
+		sender     amqpSender
+		accept     acceptFunc
+		generation uint64
+	}
+
 	// Actually: *amqp.Receiver
 	amqpReceiver interface {
 		Receive(ctx context.Context, o *amqp.ReceiveOptions) (*amqp.Message, error)
@@ -111,124 +159,204 @@ func NewLink(ctx context.Context, conn *amqp.Conn, address string, opts ...LinkO
 		return nil, err
 	}
 
-	return NewLinkWithSession(ctx, authSession, address, opts...)
+	link, err := newLinkWithSession(ctx, conn, authSession, address, opts...)
+	if err != nil {
+		closeCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+
+		_ = authSession.Close(closeCtx)
+		return nil, err
+	}
+	return link, nil
 }
 
 // NewLinkWithSession will build a new request response link, but will reuse an existing AMQP session
 func NewLinkWithSession(ctx context.Context, session *amqp.Session, address string, opts ...LinkOption) (*Link, error) {
+	return newLinkWithSession(ctx, nil, session, address, opts...)
+}
+
+func newLinkWithSession(ctx context.Context, conn *amqp.Conn, session *amqp.Session, address string, opts ...LinkOption) (*Link, error) {
 	linkID, err := uuid.NewV4()
 	if err != nil {
 		return nil, err
 	}
 
-	id := linkID.String()
-	link := &Link{
-		session:       session,
-		clientAddress: strings.Replace("$", "", address, -1) + replyPostfix + id,
-		id:            id,
-
-		uuidNewV4:               uuid.NewV4,
-		responseMap:             map[string]chan rpcResponse{},
-		startResponseRouterOnce: &sync.Once{},
-	}
-
-	for _, opt := range opts {
-		if err := opt(link); err != nil {
-			return nil, err
-		}
+	link, err := newLink(linkID.String(), session, address, opts...)
+	if err != nil {
+		return nil, err
 	}
+	link.conn = conn
 
-	sender, err := session.NewSender(ctx, address, nil)
+	sender, receiver, err := link.attach(ctx, session)
 	if err != nil {
 		return nil, err
 	}
 
-	receiverOpts := amqp.ReceiverOptions{
-		Credit:        defaultReceiverCredits,
-		TargetAddress: link.clientAddress,
-	}
+	link.sender, link.receiver = link.record(sender, receiver)
+	link.messageAccept = receiver.AcceptMessage
+	link.reattach = link.reattachSession
 
-	if link.sessionID != nil {
-		const name = "com.microsoft:session-filter"
-		const code = uint64(0x00000137000000C)
-		if link.sessionID == nil {
-			receiverOpts.Filters = append(receiverOpts.Filters, amqp.NewLinkFilter(name, code, nil))
-		} else {
-			receiverOpts.Filters = append(receiverOpts.Filters, amqp.NewLinkFilter(name, code, link.sessionID))
-		}
+	return link, nil
+}
+
+// attach opens the sender and receiver of the link on session
+func (l *Link) attach(ctx context.Context, session *amqp.Session) (*amqp.Sender, *amqp.Receiver, error) {
+	sender, err := session.NewSender(ctx, l.address, l.senderOptions())
+	if err != nil {
+		return nil, nil, err
 	}
 
-	receiver, err := session.NewReceiver(ctx, address, &receiverOpts)
+	receiver, err := session.NewReceiver(ctx, l.address, l.receiverOptions())
 	if err != nil {
 		// make sure we close the sender
 		clsCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
 		defer cancel()
 
 		_ = sender.Close(clsCtx)
-		return nil, err
+		return nil, nil, err
 	}
 
-	link.sender = sender
-	link.receiver = receiver
-	link.messageAccept = receiver.AcceptMessage
+	return sender, receiver, nil
+}
+
+// newLink builds a Link and applies opts, without attaching any AMQP links
+func newLink(id string, session *amqp.Session, address string, opts ...LinkOption) (*Link, error) {
+	link := &Link{
+		session:       session,
+		address:       address,
+		clientAddress: strings.Replace("$", "", address, -1) + replyPostfix + id,
+		id:            id,
+
+		uuidNewV4:               uuid.NewV4,
+		responseMap:             map[string]chan rpcResponse{},
+		startResponseRouterOnce: &sync.Once{},
+		retryPolicy:             defaultRetryPolicy,
+		receiverCredit:          defaultReceiverCredits,
+	}
+	link.routerCtx, link.stopRouters = context.WithCancel(context.Background())
+
+	for _, opt := range opts {
+		if err := opt(link); err != nil {
+			return nil, err
+		}
+	}
 
+	if link.limitInFlight {
+		limit := link.maxInFlight
+		if limit == 0 {
+			limit = int(link.receiverCredit)
+		}
+		link.inFlight = make(chan struct{}, limit)
+	}
 	return link, nil
 }
 
 // RetryableRPC attempts to retry a request a number of times with delay
 func (l *Link) RetryableRPC(ctx context.Context, times int, delay time.Duration, msg *amqp.Message) (*Response, error) {
+	return l.RetryableRPCWithPolicy(ctx, FixedRetryPolicy{MaxAttempts: times, Delay: delay}, msg)
+}
+
+// RetryableRPCWithPolicy attempts to retry a request as long as policy allows. If policy is nil, the policy configured
+// with LinkWithRetryPolicy is used.
+func (l *Link) RetryableRPCWithPolicy(ctx context.Context, policy RetryPolicy, msg *amqp.Message) (*Response, error) {
+	return l.retryableRPC(ctx, "", policy, msg)
+}
+
+// retryableRPC retries a request as long as policy allows, sending every attempt with messageID if it is not empty
+func (l *Link) retryableRPC(ctx context.Context, messageID string, policy RetryPolicy, msg *amqp.Message) (*Response, error) {
 	ctx, span := tracing.StartSpanFromContext(ctx, "az-amqp-common.rpc.RetryableRPC")
 	defer span.End()
 
-	res, err := common.Retry(times, delay, func() (interface{}, error) {
+	if policy == nil {
+		policy = l.retryPolicy
+	}
+
+	maxThrottle := l.maxThrottleDelay
+	if maxThrottle <= 0 {
+		maxThrottle = defaultMaxThrottleDelay
+	}
+
+	if messageID == "" && l.stableMessageIDs {
+		// every attempt is sent with the same message ID, so the service can tell they are the same request
+		id, err := l.uuidNewV4()
+		if err != nil {
+			return nil, err
+		}
+		messageID = id.String()
+	}
+
+	res, err := retry(ctx, policy, maxThrottle, l.recovery, func() (*Response, error) {
 		ctx, span := tracing.StartSpanFromContext(ctx, "az-amqp-common.rpc.RetryableRPC.retry")
 		defer span.End()
 
-		res, err := l.RPC(ctx, msg)
+		res, err := l.send(ctx, messageID, msg)
 
 		if err != nil {
 			tab.For(ctx).Error(fmt.Errorf("error in RPC via link %s: %v", l.id, err))
 			return nil, err
 		}
 
-		switch {
-		case res.Code >= 200 && res.Code < 300:
+		if res.Code >= 200 && res.Code < 300 {
 			tab.For(ctx).Debug(fmt.Sprintf("successful rpc on link %s: status code %d and description: %s", l.id, res.Code, res.Description))
 			return res, nil
-		case res.Code >= 500:
-			errMessage := fmt.Sprintf("server error link %s: status code %d and description: %s", l.id, res.Code, res.Description)
-			tab.For(ctx).Error(errors.New(errMessage))
-			return nil, common.Retryable(errMessage)
-		default:
-			errMessage := fmt.Sprintf("unhandled error link %s: status code %d and description: %s", l.id, res.Code, res.Description)
-			tab.For(ctx).Error(errors.New(errMessage))
-			return nil, common.Retryable(errMessage)
 		}
+
+		statusErr := newStatusError(res)
+		tab.For(ctx).Error(fmt.Errorf("error link %s: %w", l.id, statusErr))
+		return nil, statusErr
 	})
 	if err != nil {
 		tab.For(ctx).Error(err)
 		return nil, err
 	}
-	return res.(*Response), nil
+	return res, nil
 }
 
 // startResponseRouter is responsible for taking any messages received on the 'response'
 // link and forwarding it to the proper channel. The channel is being select'd by the
 // original `RPC` call.
+//
+// Each router serves a single generation of the link; once a recovery replaces the
+// receiver, the router of the previous generation exits. Every router exits when the
+// link is closed.
 func (l *Link) startResponseRouter() {
+	l.responseMu.Lock()
+	receiver, accept, generation := l.receiver, l.messageAccept, l.generation
+	l.responseMu.Unlock()
+
+	ctx := l.lifetime()
+	backoff := minRouterBackoff
+
 	for {
-		res, err := l.receiver.Receive(context.Background(), nil)
+		res, err := receiver.Receive(ctx, nil)
+
+		if ctx.Err() != nil {
+			l.broadcastError(generation, ErrLinkClosed)
+			break
+		}
 
 		// You'll see this when the link is shutting down (either
 		// service-initiated via 'detach' or a user-initiated shutdown)
-		if isClosedError(err) {
-			l.broadcastError(err)
+		if IsDetached(err) {
+			l.broadcastError(generation, err)
 			break
 		} else if err != nil {
-			// this is some transient error, sleep before trying again
-			time.Sleep(time.Second)
+			// this is some transient error, back off before trying again
+			l.receiveError(err)
+
+			if sleep(ctx, backoff) != nil {
+				l.broadcastError(generation, ErrLinkClosed)
+				break
+			}
+
+			if backoff *= 2; backoff > maxRouterBackoff {
+				backoff = maxRouterBackoff
+			}
+			continue
 		}
 
+		backoff = minRouterBackoff
+
 		// I don't believe this should happen. The JS version of this same code
 		// ignores errors as well since responses should always be correlated
 		// to actual send requests. So this is just here for completeness.
@@ -236,29 +364,51 @@ func (l *Link) startResponseRouter() {
 			continue
 		}
 
+		if res.Properties == nil {
+			l.orphan(accept, res, OrphanWrongType)
+			continue
+		}
+
 		autogenMessageId, ok := res.Properties.CorrelationID.(string)
 
 		if !ok {
-			// TODO: it'd be good to track these in some way. We don't have a good way to
-			// forward this on at this point.
+			l.orphan(accept, res, OrphanWrongType)
 			continue
 		}
 
-		ch := l.deleteChannelFromMap(autogenMessageId)
+		ch := l.deleteChannelFromGeneration(generation, autogenMessageId)
 
 		if ch != nil {
+			l.remember(autogenMessageId, OrphanDuplicate)
 			ch <- rpcResponse{message: res, err: err}
+		} else {
+			l.orphan(accept, res, l.orphanReason(autogenMessageId))
 		}
 	}
 }
 
 // RPC sends a request and waits on a response for that request
 func (l *Link) RPC(ctx context.Context, msg *amqp.Message) (*Response, error) {
-	l.startResponseRouterOnce.Do(func() {
-		go l.startResponseRouter()
+	return l.send(ctx, "", msg)
+}
+
+// send passes a request through the interceptors of the Link and sends it with messageID, or with a new message ID if
+// messageID is empty
+func (l *Link) send(ctx context.Context, messageID string, msg *amqp.Message) (*Response, error) {
+	return l.intercept(ctx, msg, func(ctx context.Context, msg *amqp.Message) (*Response, error) {
+		return l.rpc(ctx, messageID, msg)
 	})
+}
+
+func (l *Link) rpc(ctx context.Context, messageID string, msg *amqp.Message) (*Response, error) {
+	var copiedMessage *amqp.Message
+	var err error
 
-	copiedMessage, messageID, err := addMessageID(msg, l.uuidNewV4)
+	if messageID != "" {
+		copiedMessage = setMessageID(msg, messageID)
+	} else {
+		copiedMessage, messageID, err = addMessageID(msg, l.uuidNewV4)
+	}
 
 	if err != nil {
 		return nil, err
@@ -274,9 +424,12 @@ func (l *Link) RPC(ctx context.Context, msg *amqp.Message) (*Response, error) {
 
 	msg.Properties.ReplyTo = &l.clientAddress
 
-	if msg.ApplicationProperties == nil {
-		msg.ApplicationProperties = make(map[string]interface{})
+	// the application properties are copied so that every attempt of a retried request derives its own server-timeout
+	properties := make(map[string]interface{}, len(msg.ApplicationProperties)+1)
+	for k, v := range msg.ApplicationProperties {
+		properties[k] = v
 	}
+	msg.ApplicationProperties = properties
 
 	if _, ok := msg.ApplicationProperties["server-timeout"]; !ok {
 		if deadline, ok := ctx.Deadline(); ok {
@@ -284,32 +437,17 @@ func (l *Link) RPC(ctx context.Context, msg *amqp.Message) (*Response, error) {
 		}
 	}
 
-	responseCh := l.addChannelToMap(messageID)
-
-	if responseCh == nil {
-		return nil, &amqp.LinkError{}
-	}
-
-	err = l.sender.Send(ctx, msg, nil)
-
+	release, err := l.acquire(ctx)
 	if err != nil {
-		l.deleteChannelFromMap(messageID)
 		tab.For(ctx).Error(err)
 		return nil, err
 	}
 
-	var res *amqp.Message
-
-	select {
-	case <-ctx.Done():
-		l.deleteChannelFromMap(messageID)
-		res, err = nil, ctx.Err()
-	case resp := <-responseCh:
-		// this will get triggered by the loop in 'startReceiverRouter' when it receives
-		// a message with our autoGenMessageID set in the correlation_id property.
-		res, err = resp.message, resp.err
-	}
+	// the request stays in flight until its response has been settled, so a drain does not close the receiver
+	// before then
+	defer release()
 
+	res, accept, err := l.roundTrip(ctx, msg, messageID)
 	if err != nil {
 		tab.For(ctx).Error(err)
 		return nil, err
@@ -355,7 +493,7 @@ func (l *Link) RPC(ctx context.Context, msg *amqp.Message) (*Response, error) {
 		Message:     res,
 	}
 
-	if err := l.messageAccept(ctx, res); err != nil {
+	if err := accept(ctx, res); err != nil {
 		tab.For(ctx).Error(err)
 		return response, err
 	}
@@ -363,11 +501,98 @@ func (l *Link) RPC(ctx context.Context, msg *amqp.Message) (*Response, error) {
 	return response, err
 }
 
+// roundTrip sends msg and waits for the response correlated with messageID. If the link has been detached and
+// recovery is enabled, the link is recovered and the request is sent again, as long as it is safe to do so.
+func (l *Link) roundTrip(ctx context.Context, msg *amqp.Message, messageID string) (*amqp.Message, acceptFunc, error) {
+	for recoveries := 0; ; recoveries++ {
+		res, ep, sent, err := l.sendAndWait(ctx, msg, messageID)
+		if err == nil {
+			return res, ep.accept, nil
+		}
+
+		if !l.recovery || !isClosedError(err) || ctx.Err() != nil || recoveries >= maxRecoveries {
+			return nil, nil, err
+		}
+
+		// a request which reached the sender may have been processed by the service, so it is only sent again
+		// if the caller has said that doing so is safe
+		if sent && !l.resendInFlight {
+			return nil, nil, err
+		}
+
+		if recoverErr := l.recover(ctx, ep.generation); recoverErr != nil {
+			tab.For(ctx).Error(recoverErr)
+			return nil, nil, err
+		}
+	}
+}
+
+// sendAndWait sends msg on the current generation of the link and waits for its response. sent is false if the
+// request never reached the sender.
+func (l *Link) sendAndWait(ctx context.Context, msg *amqp.Message, messageID string) (*amqp.Message, endpoints, bool, error) {
+	l.responseMu.Lock()
+	once := l.startResponseRouterOnce
+	l.responseMu.Unlock()
+
+	once.Do(l.runResponseRouter)
+
+	responseCh, ep, err := l.register(messageID)
+
+	if err != nil {
+		return nil, ep, false, err
+	}
+
+	if responseCh == nil {
+		return nil, ep, false, &amqp.LinkError{}
+	}
+
+	if err := ep.sender.Send(ctx, msg, nil); err != nil {
+		l.deleteChannelFromGeneration(ep.generation, messageID)
+		return nil, ep, true, err
+	}
+
+	select {
+	case <-ctx.Done():
+		l.deleteChannelFromGeneration(ep.generation, messageID)
+
+		// the response may have been routed while we were giving up
+		select {
+		case resp := <-responseCh:
+			if resp.message != nil {
+				l.orphan(ep.accept, resp.message, OrphanLate)
+			}
+		default:
+			l.remember(messageID, OrphanLate)
+		}
+		return nil, ep, true, ctx.Err()
+	case resp := <-responseCh:
+		// this will get triggered by the loop in 'startReceiverRouter' when it receives
+		// a message with our autoGenMessageID set in the correlation_id property.
+		return resp.message, ep, true, resp.err
+	}
+}
+
 // Close the link receiver, sender and session
 func (l *Link) Close(ctx context.Context) error {
 	ctx, span := tracing.StartSpanFromContext(ctx, "az-amqp-common.rpc.Close")
 	defer span.End()
 
+	// wait for any recovery in progress, so the endpoints it attaches are closed as well
+	l.recoverMu.Lock()
+	defer l.recoverMu.Unlock()
+
+	l.responseMu.Lock()
+	l.closed = true
+	l.responseMu.Unlock()
+
+	err := l.closeLinks(ctx)
+	if stopErr := l.stopResponseRouters(ctx); err == nil {
+		err = stopErr
+	}
+	return err
+}
+
+func (l *Link) closeLinks(ctx context.Context) error {
 	if err := l.closeReceiver(ctx); err != nil {
 		_ = l.closeSender(ctx)
 		_ = l.closeSession(ctx)
@@ -406,7 +631,7 @@ func (l *Link) closeSession(ctx context.Context) error {
 	ctx, span := tracing.StartSpanFromContext(ctx, "az-amqp-common.rpc.closeSession")
 	defer span.End()
 
-	if l.session != nil {
+	if l.session != nil && !l.sharedSession {
 		return l.session.Close(ctx)
 	}
 	return nil
@@ -417,17 +642,40 @@ func (l *Link) closeSession(ctx context.Context) error {
 // If l.responseMap is nil (for instance, via broadcastError) this function will
 // return nil.
 func (l *Link) addChannelToMap(messageID string) chan rpcResponse {
+	ch, _, _ := l.register(messageID)
+	return ch
+}
+
+// register adds a channel for messageID to the response map of the current generation
+// and returns it along with the endpoints of that generation. The channel is nil if the
+// current generation has been closed, and ErrLinkClosed is returned if the link itself
+// has been.
+func (l *Link) register(messageID string) (chan rpcResponse, endpoints, error) {
 	l.responseMu.Lock()
 	defer l.responseMu.Unlock()
 
+	ep := endpoints{
+		sender:     l.sender,
+		accept:     l.messageAccept,
+		generation: l.generation,
+	}
+
+	if l.closed {
+		return nil, ep, ErrLinkClosed
+	}
+
 	if l.responseMap == nil {
-		return nil
+		return nil, ep, nil
+	}
+
+	if _, ok := l.responseMap[messageID]; ok {
+		return nil, ep, ErrRequestInFlight
 	}
 
 	responseCh := make(chan rpcResponse, 1)
 	l.responseMap[messageID] = responseCh
 
-	return responseCh
+	return responseCh, ep, nil
 }
 
 // deleteChannelFromMap removes the message from our internal map and returns
@@ -438,27 +686,54 @@ func (l *Link) deleteChannelFromMap(messageID string) chan rpcResponse {
 	l.responseMu.Lock()
 	defer l.responseMu.Unlock()
 
+	return l.deleteChannelLocked(messageID)
+}
+
+// deleteChannelFromGeneration is deleteChannelFromMap, but does nothing if the link has
+// moved on from generation.
+func (l *Link) deleteChannelFromGeneration(generation uint64, messageID string) chan rpcResponse {
+	l.responseMu.Lock()
+	defer l.responseMu.Unlock()
+
+	if l.generation != generation {
+		return nil
+	}
+	return l.deleteChannelLocked(messageID)
+}
+
+func (l *Link) deleteChannelLocked(messageID string) chan rpcResponse {
 	if l.responseMap == nil {
 		return nil
 	}
 
 	ch := l.responseMap[messageID]
 	delete(l.responseMap, messageID)
+	l.checkDrainedLocked()
 
 	return ch
 }
 
 // broadcastError notifies the anyone waiting for a response that the link/session/connection
-// has closed.
-func (l *Link) broadcastError(err error) {
+// has closed. It does nothing if the link has already been recovered past generation. Once
+// the link has been closed by the caller, waiters receive ErrLinkClosed.
+func (l *Link) broadcastError(generation uint64, err error) {
 	l.responseMu.Lock()
 	defer l.responseMu.Unlock()
 
+	if l.generation != generation {
+		return
+	}
+
+	if l.closed {
+		err = ErrLinkClosed
+	}
+
 	for _, ch := range l.responseMap {
 		ch <- rpcResponse{err: err}
 	}
 
 	l.responseMap = nil
+	l.checkDrainedLocked()
 }
 
 // addMessageID generates a unique UUID for the message. When the service
@@ -476,25 +751,45 @@ func addMessageID(message *amqp.Message, uuidNewV4 func() (uuid.UUID, error)) (*
 
 	autoGenMessageID := uuid.String()
 
+	return setMessageID(message, autoGenMessageID), autoGenMessageID, nil
+}
+
+// setMessageID copies 'message', setting its message ID to messageID
+func setMessageID(message *amqp.Message, messageID string) *amqp.Message {
 	// we need to modify the message so we'll make a copy
 	copiedMessage := *message
 
 	if message.Properties == nil {
 		copiedMessage.Properties = &amqp.MessageProperties{
-			MessageID: autoGenMessageID,
+			MessageID: messageID,
 		}
 	} else {
 		// properties already exist, make a copy and then update
 		// the message ID
 		copiedProperties := *message.Properties
-		copiedProperties.MessageID = autoGenMessageID
+		copiedProperties.MessageID = messageID
 
 		copiedMessage.Properties = &copiedProperties
 	}
 
-	return &copiedMessage, autoGenMessageID, nil
+	return &copiedMessage
+}
+
+// IsDetached returns true if err means a Link can no longer be used, because it has been closed or because the link,
+// session or connection underneath it was detached or closed, whether or not the service gave a reason
+func IsDetached(err error) bool {
+	var connError *amqp.ConnError
+	var sessionError *amqp.SessionError
+	var linkError *amqp.LinkError
+
+	return errors.Is(err, ErrLinkClosed) ||
+		errors.As(err, &linkError) ||
+		errors.As(err, &sessionError) ||
+		errors.As(err, &connError)
 }
 
+// isClosedError returns true if err means the link was closed locally, or detached without an error from the service.
+// A detach which carries an error, such as unauthorized access, is reported to the caller rather than recovered from.
 func isClosedError(err error) bool {
 	var connError *amqp.ConnError
 	var sessionError *amqp.SessionError
@@ -327,3 +327,98 @@ func (s *fakeSender) Send(ctx context.Context, msg *amqp.Message, o *amqp.SendOp
 func (fs *fakeSender) Close(ctx context.Context) error {
 	panic("Not used for this test")
 }
+
+// fakeBroker implements both amqpSender and amqpReceiver. Every message sent to it is passed to respond and the
+// returned message, if any, is delivered to the receiving side correlated with the request.
+type fakeBroker struct {
+	mu        sync.Mutex
+	sent      []*amqp.Message
+	respond   func(req *amqp.Message) *amqp.Message
+	responses chan *amqp.Message
+	closed    chan struct{}
+	closeOnce sync.Once
+	detachErr *amqp.Error
+}
+
+func newFakeBroker(respond func(req *amqp.Message) *amqp.Message) *fakeBroker {
+	return &fakeBroker{
+		respond:   respond,
+		responses: make(chan *amqp.Message, 100),
+		closed:    make(chan struct{}),
+	}
+}
+
+func (b *fakeBroker) Send(ctx context.Context, msg *amqp.Message, o *amqp.SendOptions) error {
+	b.mu.Lock()
+	b.sent = append(b.sent, msg)
+	b.mu.Unlock()
+
+	if b.respond == nil {
+		return nil
+	}
+
+	if res := b.respond(msg); res != nil {
+		if res.Properties == nil {
+			res.Properties = &amqp.MessageProperties{}
+		}
+		if res.Properties.CorrelationID == nil {
+			res.Properties.CorrelationID = msg.Properties.MessageID
+		}
+		b.responses <- res
+	}
+	return nil
+}
+
+func (b *fakeBroker) Receive(ctx context.Context, o *amqp.ReceiveOptions) (*amqp.Message, error) {
+	select {
+	case res := <-b.responses:
+		return res, nil
+	case <-b.closed:
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		return nil, &amqp.LinkError{RemoteErr: b.detachErr}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (b *fakeBroker) Close(ctx context.Context) error {
+	b.closeOnce.Do(func() { close(b.closed) })
+	return nil
+}
+
+// detach closes the broker as the service would, reporting remoteErr to the receiving side
+func (b *fakeBroker) detach(remoteErr *amqp.Error) {
+	b.mu.Lock()
+	b.detachErr = remoteErr
+	b.mu.Unlock()
+	_ = b.Close(context.Background())
+}
+
+func (b *fakeBroker) Sent() []*amqp.Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]*amqp.Message(nil), b.sent...)
+}
+
+// newTestLink builds a Link which sends its requests to broker
+func newTestLink(t *testing.T, broker *fakeBroker, opts ...LinkOption) *Link {
+	link, err := newLink("test-link", nil, "$management", opts...)
+	require.NoError(t, err)
+
+	link.sender = broker
+	link.receiver = broker
+	link.messageAccept = func(ctx context.Context, message *amqp.Message) error {
+		return nil
+	}
+	return link
+}
+
+func statusResponse(code int32, description string) *amqp.Message {
+	return &amqp.Message{
+		ApplicationProperties: map[string]interface{}{
+			"status-code":        code,
+			"status-description": description,
+		},
+	}
+}
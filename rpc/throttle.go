@@ -0,0 +1,106 @@
+package rpc
+
+//	MIT License
+//
+//	Copyright (c) Microsoft Corporation. All rights reserved.
+//
+//	Permission is hereby granted, free of charge, to any person obtaining a copy
+//	of this software and associated documentation files (the "Software"), to deal
+//	in the Software without restriction, including without limitation the rights
+//	to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//	copies of the Software, and to permit persons to whom the Software is
+//	furnished to do so, subject to the following conditions:
+//
+//	The above copyright notice and this permission notice shall be included in all
+//	copies or substantial portions of the Software.
+//
+//	THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//	AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//	LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//	OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//	SOFTWARE
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Azure/go-amqp"
+)
+
+const (
+	serverBusyCondition     = "com.microsoft:server-busy"
+	defaultMaxThrottleDelay = 1 * time.Minute
+)
+
+// retryAfterKeys are the application properties, and error info keys, which may carry a retry-after hint
+var retryAfterKeys = []string{"com.microsoft:retry-after", "retry-after"}
+
+// LinkWithMaxThrottleDelay caps how long a retry waits when the service asks the client to back off. The default is
+// one minute.
+func LinkWithMaxThrottleDelay(max time.Duration) LinkOption {
+	return func(l *Link) error {
+		if max <= 0 {
+			return errors.New("max throttle delay must be positive")
+		}
+		l.maxThrottleDelay = max
+		return nil
+	}
+}
+
+// throttleDelay returns true if err means the service is throttling the client, along with how long the service asked
+// the client to wait, if it did
+func throttleDelay(err error) (time.Duration, bool) {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.RetryAfter, statusErr.Throttled
+	}
+
+	var linkErr *amqp.LinkError
+	if errors.As(err, &linkErr) && linkErr.RemoteErr != nil && string(linkErr.RemoteErr.Condition) == serverBusyCondition {
+		return retryAfter(linkErr.RemoteErr.Info), true
+	}
+	return 0, false
+}
+
+// isThrottled returns true if a response with the given code and error condition means the service is throttling the
+// client
+func isThrottled(code int, condition string) bool {
+	return code == http.StatusTooManyRequests || condition == serverBusyCondition
+}
+
+// retryAfter reads a retry-after hint, in milliseconds, from the properties of a response or error
+func retryAfter(properties map[string]interface{}) time.Duration {
+	for _, key := range retryAfterKeys {
+		switch v := properties[key].(type) {
+		case int32:
+			return positiveMillis(int64(v))
+		case int64:
+			return positiveMillis(v)
+		case uint32:
+			return positiveMillis(int64(v))
+		case uint64:
+			if v < 1<<62 {
+				return positiveMillis(int64(v))
+			}
+		case int:
+			return positiveMillis(int64(v))
+		case uint:
+			return positiveMillis(int64(v))
+		case time.Duration:
+			if v > 0 {
+				return v
+			}
+		}
+	}
+	return 0
+}
+
+func positiveMillis(ms int64) time.Duration {
+	if ms <= 0 {
+		return 0
+	}
+	return time.Duration(ms) * time.Millisecond
+}
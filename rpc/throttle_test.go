@@ -0,0 +1,141 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-amqp"
+	"github.com/stretchr/testify/require"
+)
+
+func throttledResponse(code int32, retryAfter interface{}) *amqp.Message {
+	res := statusResponse(code, "server busy")
+	res.ApplicationProperties["error-condition"] = serverBusyCondition
+	if retryAfter != nil {
+		res.ApplicationProperties["com.microsoft:retry-after"] = retryAfter
+	}
+	return res
+}
+
+func TestRetryableRPCHonorsRetryAfter(t *testing.T) {
+	broker := newFakeBroker(func(req *amqp.Message) *amqp.Message {
+		return throttledResponse(503, int64(50))
+	})
+	link := newTestLink(t, broker)
+
+	start := time.Now()
+	_, err := link.RetryableRPCWithPolicy(context.Background(), FixedRetryPolicy{MaxAttempts: 2, Delay: time.Millisecond}, &amqp.Message{})
+	require.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+
+	var statusErr *StatusError
+	require.ErrorAs(t, err, &statusErr)
+	require.True(t, statusErr.Throttled)
+	require.Equal(t, 50*time.Millisecond, statusErr.RetryAfter)
+	require.Len(t, broker.Sent(), 2)
+}
+
+func TestRetryableRPCCapsRetryAfter(t *testing.T) {
+	broker := newFakeBroker(func(req *amqp.Message) *amqp.Message {
+		return throttledResponse(429, uint32(60000))
+	})
+	link := newTestLink(t, broker, LinkWithMaxThrottleDelay(10*time.Millisecond))
+
+	start := time.Now()
+	_, err := link.RetryableRPC(context.Background(), 2, time.Millisecond, &amqp.Message{})
+	require.Less(t, time.Since(start), 5*time.Second)
+
+	var statusErr *StatusError
+	require.ErrorAs(t, err, &statusErr)
+	require.Equal(t, time.Minute, statusErr.RetryAfter)
+	require.Len(t, broker.Sent(), 2)
+}
+
+func TestRetryableRPCGivesUpWhenThrottledPastDeadline(t *testing.T) {
+	broker := newFakeBroker(func(req *amqp.Message) *amqp.Message {
+		return throttledResponse(429, int64(30000))
+	})
+	link := newTestLink(t, broker)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	_, err := link.RetryableRPC(ctx, 3, time.Millisecond, &amqp.Message{})
+
+	var statusErr *StatusError
+	require.ErrorAs(t, err, &statusErr, "the throttling error is returned rather than the context's")
+	require.True(t, statusErr.Throttled)
+	require.Len(t, broker.Sent(), 1)
+	require.NoError(t, ctx.Err())
+}
+
+func TestThrottledStatusErrors(t *testing.T) {
+	statusErr := newStatusError(&Response{Code: 429, Message: statusResponse(429, "slow down")})
+	require.True(t, statusErr.Throttled)
+	require.Zero(t, statusErr.RetryAfter)
+
+	statusErr = newStatusError(&Response{Code: 503, Message: throttledResponse(503, nil)})
+	require.True(t, statusErr.Throttled)
+
+	statusErr = newStatusError(&Response{Code: 503, Message: statusResponse(503, "unavailable")})
+	require.False(t, statusErr.Throttled)
+}
+
+func TestThrottledLinkErrors(t *testing.T) {
+	err := &amqp.LinkError{RemoteErr: &amqp.Error{
+		Condition: serverBusyCondition,
+		Info:      map[string]interface{}{"retry-after": int32(250)},
+	}}
+
+	delay, throttled := throttleDelay(err)
+	require.True(t, throttled)
+	require.Equal(t, 250*time.Millisecond, delay)
+	require.True(t, isRetryable(err, true))
+	require.False(t, isRetryable(err, false), "a link which cannot recover is not retried after a detach")
+
+	_, throttled = throttleDelay(&amqp.LinkError{RemoteErr: &amqp.Error{Condition: amqp.ErrCondDetachForced}})
+	require.False(t, throttled)
+
+	_, throttled = throttleDelay(errors.New("other"))
+	require.False(t, throttled)
+}
+
+func TestRetryableRPCDoesNotRetryServerBusyDetachWithoutRecovery(t *testing.T) {
+	var broker *fakeBroker
+	broker = newFakeBroker(func(req *amqp.Message) *amqp.Message {
+		broker.detach(&amqp.Error{Condition: serverBusyCondition, Description: "server busy"})
+		return nil
+	})
+	link := newTestLink(t, broker)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := link.RetryableRPCWithPolicy(ctx, FixedRetryPolicy{MaxAttempts: 3, Delay: time.Millisecond}, &amqp.Message{})
+	var linkErr *amqp.LinkError
+	require.ErrorAs(t, err, &linkErr)
+	require.NotNil(t, linkErr.RemoteErr)
+	require.EqualValues(t, serverBusyCondition, linkErr.RemoteErr.Condition)
+	require.Len(t, broker.Sent(), 1)
+}
+
+func TestRetryableRPCRetriesServerBusyDetachWithRecovery(t *testing.T) {
+	var first *fakeBroker
+	first = newFakeBroker(func(req *amqp.Message) *amqp.Message {
+		first.detach(&amqp.Error{Condition: serverBusyCondition, Description: "server busy"})
+		return nil
+	})
+	link := newTestLink(t, first, LinkWithRecovery())
+	second := okBroker()
+	withReattach(link, second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := link.RetryableRPCWithPolicy(ctx, FixedRetryPolicy{MaxAttempts: 3, Delay: time.Millisecond}, &amqp.Message{})
+	require.NoError(t, err)
+	require.Equal(t, 200, res.Code)
+	require.Len(t, first.Sent(), 1)
+	require.Len(t, second.Sent(), 1)
+}
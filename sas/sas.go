@@ -25,6 +25,7 @@ package sas
 //	SOFTWARE
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
@@ -119,6 +120,14 @@ func (t *TokenProvider) GetToken(audience string) (*auth.Token, error) {
 	return auth.NewToken(auth.CBSTokenTypeSAS, signature, expiry), nil
 }
 
+// GetTokenWithContext gets a CBS SAS token. Signing happens locally, so ctx is only checked for cancellation.
+func (t *TokenProvider) GetTokenWithContext(ctx context.Context, audience string) (*auth.Token, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return t.GetToken(audience)
+}
+
 // NewSigner builds a new SAS signer for use in generation Service Bus and Event Hub SAS tokens
 func NewSigner(keyName, key string) *Signer {
 	return &Signer{
@@ -1,6 +1,7 @@
 package sas
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"strconv"
@@ -42,6 +43,20 @@ func TestNewSigner(t *testing.T) {
 	assert.NotNil(t, sig.sig)
 }
 
+func TestTokenProviderGetTokenWithContext(t *testing.T) {
+	provider, err := NewTokenProvider(TokenProviderWithKey("foo", "superSecret"))
+	require.NoError(t, err)
+
+	token, err := provider.GetTokenWithContext(context.Background(), "http://microsoft.com")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(token.Token, sas+" "))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = provider.GetTokenWithContext(ctx, "http://microsoft.com")
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
 func parseSig(sigStr string) (*sig, error) {
 	if !strings.HasPrefix(sigStr, sas+" ") {
 		return nil, errors.New("should start with " + sas)